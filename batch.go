@@ -0,0 +1,224 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	batch.go
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/karlramberg/loupe/photo"
+)
+
+// One row of a batch file, a file to name and the attributes to give it. Empty attributes fall back
+// to the flags given to name, then to the same defaults the prompts use
+type BatchEntry struct {
+	File       string `json:"file"`
+	Date       string `json:"date"`
+	Roll       string `json:"roll"`
+	Number     string `json:"number"`
+	Class      string `json:"class"`
+	Group      string `json:"group"`
+	Version    string `json:"version"`
+	Subversion string `json:"subversion"`
+}
+
+// The columns a batch CSV can have, in the order they are usually written
+var batchColumns = []string{"file", "date", "roll", "number", "class", "group", "version", "subversion"}
+
+// Reads a batch file, choosing CSV or JSON by its extension
+func readBatch(path string) ([]BatchEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Join(errors.New("trouble opening the batch file \""+path+"\""), err)
+	}
+	defer file.Close()
+
+	var entries []BatchEntry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		entries, err = readBatchCSV(file)
+	case ".json":
+		entries, err = readBatchJSON(file)
+	default:
+		return nil, errors.New("batch file \"" + path + "\" should be a .csv or .json file")
+	}
+	if err != nil {
+		return nil, errors.Join(errors.New("trouble reading the batch file \""+path+"\""), err)
+	}
+
+	if len(entries) == 0 {
+		return nil, errors.New("batch file \"" + path + "\" has no files in it")
+	}
+	return entries, nil
+}
+
+// Reads a CSV with a header row naming its columns. Only the file column is required
+func readBatchCSV(r io.Reader) ([]BatchEntry, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	// Find which column each attribute is in
+	columns := make(map[string]int)
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if !slices.Contains(batchColumns, column) {
+			return nil, errors.New("unknown column \"" + column + "\". Use " + strings.Join(batchColumns, ", "))
+		}
+		columns[column] = i
+	}
+	if _, ok := columns["file"]; !ok {
+		return nil, errors.New("there is no file column")
+	}
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]BatchEntry, len(records))
+	for i, record := range records {
+		get := func(column string) string {
+			if index, ok := columns[column]; ok {
+				return strings.TrimSpace(record[index])
+			}
+			return ""
+		}
+
+		entries[i] = BatchEntry{
+			File:       get("file"),
+			Date:       get("date"),
+			Roll:       get("roll"),
+			Number:     get("number"),
+			Class:      get("class"),
+			Group:      get("group"),
+			Version:    get("version"),
+			Subversion: get("subversion"),
+		}
+	}
+	return entries, nil
+}
+
+// Reads a JSON list of entries
+func readBatchJSON(r io.Reader) (entries []BatchEntry, err error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&entries)
+	return
+}
+
+// Reads the batch file and builds the photograph each file will be named as. Every attribute is
+// checked the same way the prompts check them, and every problem in the file is reported at once
+func readBatchNames(dir string, files []string, opts NameOptions) (paths []string, photographs []photo.Photograph, err error) {
+	entries, err := readBatch(opts.batch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Anything a row leaves out comes from the flags, then the defaults
+	fallback := func(value, flagValue, defaultValue string) string {
+		if value != "" {
+			return value
+		}
+		if flagValue != "" {
+			return flagValue
+		}
+		return defaultValue
+	}
+
+	// Files in the batch can be absolute or relative to the working directory, so match them by
+	// their absolute paths
+	imageFiles := make(map[string]string)
+	for _, file := range files {
+		if abs, err := filepath.Abs(file); err == nil {
+			imageFiles[abs] = file
+		}
+	}
+
+	var problems []error
+	seen := make(map[string]bool)
+	for i, entry := range entries {
+		// Entries are counted from 1, not counting the header of a CSV
+		fail := func(err error) {
+			problems = append(problems, errors.New(fmt.Sprintf("entry %d: ", i+1)+err.Error()))
+		}
+
+		// Every file has to be an image file inside the working directory
+		if entry.File == "" {
+			fail(errors.New("no file given"))
+			continue
+		}
+		path := entry.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		abs, err := filepath.Abs(path)
+		path, ok := imageFiles[abs]
+		if err != nil || !ok {
+			fail(errors.New("\"" + entry.File + "\" is not an image file in \"" + dir + "\""))
+			continue
+		}
+		if seen[path] {
+			fail(errors.New("\"" + entry.File + "\" is given more than once"))
+			continue
+		}
+		seen[path] = true
+
+		var photograph photo.Photograph
+		check := func(attribute, value string, checker func(string) (string, error)) string {
+			checked, err := checker(value)
+			if err != nil {
+				fail(errors.New("invalid " + attribute + ", " + err.Error()))
+			}
+			return checked
+		}
+
+		photograph.Date = check("date", fallback(entry.Date, opts.date, "auto"), checkDate)
+		photograph.Letter = check("roll", fallback(entry.Roll, opts.roll, "none"), checkLetter)
+		photograph.Class = check("class", fallback(entry.Class, opts.class, "none"), checkWord)
+		photograph.Group = check("group", fallback(entry.Group, opts.group, "default"), checkWord)
+		photograph.Version = check("version", fallback(entry.Version, opts.version, "lolidk"), checkWord)
+		photograph.Subversion = check("subversion", fallback(entry.Subversion, opts.subversion, "none"), checkWord)
+
+		// A number given in the batch file is used as is, otherwise the file is counted like name does
+		if entry.Number != "" {
+			number, _ := strconv.Atoi(check("number", entry.Number, checkStart))
+			photograph.Number = padNumber(number, photograph.Letter)
+		}
+
+		if photograph.Date == "auto" {
+			// Only files with a trustworthy automatic date can go without one in the batch file
+			date, source := autoDate(path)
+			if problem := autoDateProblem(path, date, source); problem != "" {
+				fail(errors.New("\"" + entry.File + "\" " + problem + ", give it a date"))
+				continue
+			}
+			photograph.Date = date
+			fmt.Printf(" %s  %s  from %s\n", path, date, source)
+		}
+
+		paths = append(paths, path)
+		photographs = append(photographs, photograph)
+	}
+
+	if len(problems) > 0 {
+		return nil, nil, errors.Join(append([]error{errors.New("problems in the batch file \"" + opts.batch + "\"")}, problems...)...)
+	}
+	return paths, photographs, nil
+}
@@ -0,0 +1,249 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	check.go
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/karlramberg/loupe/photo"
+)
+
+// Checks that every identifier in an archive belongs to one photograph and that everything is where
+// sort would put it. Returns how many problems were found, so scripts can fail on any of them
+func check(dir string) (int, error) {
+	fmt.Println("Loupe", loupeVersion, "-", "Check")
+
+	// Check that the -a flag was used
+	if dir == "" {
+		return 0, errors.New("provide an archive directory using the -a flag")
+	}
+
+	// Check that the given directory exists
+	stats, err := os.Stat(dir)
+	if os.IsNotExist(err) || !stats.IsDir() {
+		return 0, errors.New("directory \"" + dir + "\" not found")
+	}
+
+	// Get a list of image files in the directory and its subdirectories, from the index if there is one
+	files, err := getArchiveFiles(dir, false)
+	if err != nil {
+		return 0, errors.Join(errors.New("trouble getting image files from \""+dir+"\""), err)
+	}
+
+	// Sort every file by its name and identifier, keeping track of the ones that are invalid
+	// or not where they should be
+	var invalidFiles, misplacedFiles []string
+	folders := make(map[string][]string)
+	groupings := make(map[string][]string)
+	shot := make(map[string]bool)
+	for _, file := range files {
+		name := filepath.Base(file.Path)
+		folders[name] = append(folders[name], filepath.Dir(file.Path))
+
+		if file.Err != nil {
+			invalidFiles = append(invalidFiles, file.Path)
+			continue
+		}
+
+		photograph := file.Photograph
+		if filepath.Dir(file.Path) != filepath.Join(dir, photograph.Directory()) {
+			misplacedFiles = append(misplacedFiles, file.Path)
+		}
+
+		if photograph.Letter != photo.None {
+			shot[photograph.Date+"-"+photograph.Letter] = true
+		}
+
+		identifier := photograph.Identifier()
+		grouping := joinOptional(photograph.Class, photograph.Group)
+		if !slices.Contains(groupings[identifier], grouping) {
+			groupings[identifier] = append(groupings[identifier], grouping)
+		}
+	}
+
+	emptyDirs, err := findEmptyDirs(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	// Renaming files doesn't change the registry or the catalogue, so a roll or a location can be
+	// left behind with no files
+	registry, _, err := readRolls(dir)
+	if err != nil {
+		return 0, err
+	}
+	var orphanedRolls []string
+	for _, roll := range registry {
+		if !shot[roll.Roll] {
+			orphanedRolls = append(orphanedRolls, roll.Roll)
+		}
+	}
+
+	locations, _, err := readLocations(dir)
+	if err != nil {
+		return 0, err
+	}
+	var orphanedLocations []Location
+	for _, location := range locations {
+		if _, ok := groupings[location.Identifier]; !ok {
+			orphanedLocations = append(orphanedLocations, location)
+		}
+	}
+
+	problems := 0
+
+	// The same filename in two places means one photograph was copied, or two were given one name
+	var names []string
+	for name, dirs := range folders {
+		if len(dirs) > 1 {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	if len(names) > 0 {
+		fmt.Println("Same filename in different folders:")
+		for _, name := range names {
+			fmt.Println("    " + name)
+			for _, d := range folders[name] {
+				fmt.Println("        " + d)
+			}
+		}
+		problems += len(names)
+	}
+
+	// Every version of a photograph shares its class and group, so disagreement means two
+	// photographs were given the same identifier
+	var identifiers []string
+	for identifier, grouping := range groupings {
+		if len(grouping) > 1 {
+			identifiers = append(identifiers, identifier)
+		}
+	}
+	slices.Sort(identifiers)
+	if len(identifiers) > 0 {
+		fmt.Println("Identifiers used in more than one group:")
+		for _, identifier := range identifiers {
+			slices.Sort(groupings[identifier])
+			fmt.Println("    " + identifier + "  " + strings.Join(groupings[identifier], ", "))
+		}
+		problems += len(identifiers)
+	}
+
+	if len(misplacedFiles) > 0 {
+		fmt.Println("Files not in their folder, run sort to move them:")
+		for _, file := range misplacedFiles {
+			fmt.Println("    " + file)
+		}
+		problems += len(misplacedFiles)
+	}
+
+	if len(invalidFiles) > 0 {
+		fmt.Println("Invalid names:")
+		fmt.Print(indent(getFixList(invalidFiles)))
+		problems += len(invalidFiles)
+	}
+
+	if len(emptyDirs) > 0 {
+		fmt.Println("Empty folders:")
+		for _, d := range emptyDirs {
+			fmt.Println("    " + d)
+		}
+		problems += len(emptyDirs)
+	}
+
+	if len(orphanedRolls) > 0 {
+		fmt.Println("Rolls in the registry with no frames in the archive, renamed or not scanned yet:")
+		for _, roll := range orphanedRolls {
+			fmt.Println("    " + roll)
+		}
+		problems += len(orphanedRolls)
+	}
+
+	if len(orphanedLocations) > 0 {
+		fmt.Println("Locations in the catalogue with no files in the archive:")
+		for _, location := range orphanedLocations {
+			fmt.Println("    " + location.Identifier + "  " + location.Kind + "  " + location.Location)
+		}
+		problems += len(orphanedLocations)
+	}
+
+	if problems == 0 {
+		fmt.Println("No problems found in", len(files), "file(s)")
+	} else {
+		fmt.Println(problems, "problem(s) found in", len(files), "file(s)")
+	}
+	return problems, nil
+}
+
+// Finds every folder with no files anywhere inside it, leaving out the folders inside one that is
+// already listed. Underscore directories are ignored like everywhere else
+func findEmptyDirs(dir string) (emptyDirs []string, err error) {
+	var walk func(dir string) (bool, error)
+	walk = func(dir string) (bool, error) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return false, errors.Join(errors.New("trouble while reading \""+dir+"\""), err)
+		}
+
+		hasFiles := false
+		var empty []string
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				hasFiles = true
+				continue
+			}
+			if entry.Name()[0] == '_' {
+				hasFiles = true
+				continue
+			}
+
+			path := filepath.Join(dir, entry.Name())
+			childHasFiles, err := walk(path)
+			if err != nil {
+				return false, err
+			}
+			if childHasFiles {
+				hasFiles = true
+			} else {
+				empty = append(empty, path)
+			}
+		}
+
+		// Only list the empty children if this folder won't be listed itself
+		if hasFiles {
+			emptyDirs = append(emptyDirs, empty...)
+		}
+		return hasFiles, nil
+	}
+
+	hasFiles, err := walk(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !hasFiles {
+		emptyDirs = append(emptyDirs, filepath.Clean(dir))
+	}
+
+	slices.Sort(emptyDirs)
+	return emptyDirs, nil
+}
+
+// Indents every line of a block of text
+func indent(text string) string {
+	lines := strings.SplitAfter(text, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = "    " + line
+		}
+	}
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,248 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	dupes.go
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/karlramberg/loupe/photo"
+)
+
+// Finds every file in the archive with the same contents as another, and every filename used by
+// files with different contents. With remove set, every exact duplicate is deleted except for one,
+// as long as it shares its name or identifier with the one that is kept. Files that are identical
+// under different names may be different photographs that happen to match, like two blank
+// frames, so those are only deleted with acrossNames set as well
+func dupes(dir string, remove, acrossNames, dryRun bool, jsonPath string) error {
+	fmt.Println("Loupe", loupeVersion, "-", "Dupes")
+
+	// Check that the -a flag was used
+	if dir == "" {
+		return errors.New("provide an archive directory using the -a flag")
+	}
+
+	// Check that the given directory exists
+	stats, err := os.Stat(dir)
+	if os.IsNotExist(err) || !stats.IsDir() {
+		return errors.New("directory \"" + dir + "\" not found")
+	}
+
+	// Setup a scanner to standard input for the user to answer any questions
+	scanner := bufio.NewScanner(os.Stdin)
+
+	// Deal with a change that was cut off before deleting anything
+	if remove && !dryRun {
+		okay, err := recoverInterrupted(dir, scanner)
+		if err != nil || !okay {
+			return err
+		}
+	}
+
+	// Get a list of image files in the directory and its subdirectories
+	files, err := getImageFiles(dir)
+	if err != nil {
+		return errors.Join(errors.New("trouble getting image files from \""+dir+"\""), err)
+	}
+
+	// Files can only be identical if they are the same size, and can only conflict if they have
+	// the same name, so those are the only files worth reading
+	sizes := make(map[int64][]string)
+	names := make(map[string][]string)
+	for _, file := range files {
+		stats, err := os.Stat(file)
+		if err != nil {
+			return errors.Join(errors.New("trouble reading \""+file+"\""), err)
+		}
+		// Empty files are all identical to each other, which says nothing about them
+		if stats.Size() > 0 {
+			sizes[stats.Size()] = append(sizes[stats.Size()], file)
+		}
+		names[filepath.Base(file)] = append(names[filepath.Base(file)], file)
+	}
+
+	sums := make(map[string]string)
+	for _, groups := range []map[string][]string{names, bySize(sizes)} {
+		for _, group := range groups {
+			if len(group) < 2 {
+				continue
+			}
+			for _, file := range group {
+				if _, ok := sums[file]; ok {
+					continue
+				}
+				sums[file], err = hashFile(file)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// Group the files that were read by their contents
+	contents := make(map[string][]string)
+	for _, file := range files {
+		if sum, ok := sums[file]; ok {
+			contents[sum] = append(contents[sum], file)
+		}
+	}
+
+	var duplicateGroups [][]string
+	for _, group := range contents {
+		if len(group) > 1 {
+			slices.SortFunc(group, func(a, b string) int {
+				return compareKeepers(dir, a, b)
+			})
+			duplicateGroups = append(duplicateGroups, group)
+		}
+	}
+	slices.SortFunc(duplicateGroups, func(a, b []string) int {
+		return strings.Compare(a[0], b[0])
+	})
+
+	// A name shared by files with different contents needs someone to decide which is right
+	var conflicts []string
+	for name, group := range names {
+		different := make(map[string]bool)
+		for _, file := range group {
+			different[sums[file]] = true
+		}
+		if len(different) > 1 {
+			conflicts = append(conflicts, name)
+		}
+	}
+	slices.Sort(conflicts)
+
+	// Plan deleting every duplicate of a file that is kept, the first of each group being the one
+	// best placed in the archive. A duplicate under another name is kept too, unless acrossNames
+	plan := newPlan("dupes", dir)
+	var skipped int
+	if len(duplicateGroups) > 0 {
+		fmt.Println("Exact duplicates:")
+		for _, group := range duplicateGroups {
+			kept := []string{group[0]}
+			fmt.Println("    keep    " + group[0])
+			for _, file := range group[1:] {
+				keeper := ""
+				for _, k := range kept {
+					if acrossNames || sameName(k, file) {
+						keeper = k
+						break
+					}
+				}
+
+				if keeper == "" {
+					fmt.Println("    other   " + file)
+					kept = append(kept, file)
+					skipped++
+					continue
+				}
+				fmt.Println("    delete  " + file)
+				plan.delete(file, keeper)
+			}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		fmt.Println("Same name with different contents, check which one to keep:")
+		for _, name := range conflicts {
+			fmt.Println("    " + name)
+			for _, file := range names[name] {
+				fmt.Println("        " + filepath.Dir(file))
+			}
+		}
+	}
+
+	fmt.Println(len(plan.Actions), "exact duplicate(s),", skipped, "under another name,", len(conflicts), "conflict(s)")
+	if skipped > 0 && !acrossNames {
+		fmt.Println("Duplicates under another name are only deleted with -across-names")
+	}
+
+	// Only touch anything if the user asked for it
+	if !remove || len(plan.Actions) == 0 {
+		if len(plan.Actions) > 0 {
+			fmt.Println("Run again with -remove to delete the exact duplicates")
+		}
+		return nil
+	}
+
+	// The sidecars of a deleted duplicate go to the copy that is kept
+	err = plan.followSidecars()
+	if err != nil {
+		return err
+	}
+
+	_, err = plan.cleanEmptyDirs(dir)
+	if err != nil {
+		return err
+	}
+
+	// A dry run only shows the plan, there is nothing to confirm
+	if dryRun {
+		return plan.run(dryRun, jsonPath)
+	}
+
+	// Ask the user for a final confirmation of the changes
+	fmt.Print(plan.table())
+	okay, err := promptConfimation(scanner, "Delete these duplicates?")
+	if err != nil {
+		return err
+	}
+
+	if okay {
+		fmt.Println("Okay!")
+		return plan.run(dryRun, jsonPath)
+	}
+
+	fmt.Println("Aborting!")
+	return nil
+}
+
+// Puts the groups of files of the same size in a map like the one for names
+func bySize(sizes map[int64][]string) map[string][]string {
+	groups := make(map[string][]string)
+	for size, group := range sizes {
+		groups[fmt.Sprint(size)] = group
+	}
+	return groups
+}
+
+// True if two files have the same name, or are validly named and share an identifier
+func sameName(a, b string) bool {
+	if filepath.Base(a) == filepath.Base(b) {
+		return true
+	}
+
+	photoA, errA := photo.Parse(filepath.Base(a))
+	photoB, errB := photo.Parse(filepath.Base(b))
+	return errA == nil && errB == nil && photoA.Identifier() == photoB.Identifier()
+}
+
+// Orders identical files by which one is best to keep. A validly named file in the folder sort
+// would put it in beats one that's only validly named, which beats an invalidly named one
+func compareKeepers(dir, a, b string) int {
+	if rankA, rankB := keeperRank(dir, a), keeperRank(dir, b); rankA != rankB {
+		return rankA - rankB
+	}
+	return strings.Compare(a, b)
+}
+
+func keeperRank(dir, file string) int {
+	photograph, err := photo.Parse(filepath.Base(file))
+	if err != nil {
+		return 2
+	}
+	if filepath.Dir(file) != filepath.Join(dir, photograph.Directory()) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,57 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	dupes_test.go
+*/
+
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Without -across-names only the duplicate sharing a name with the kept copy is deleted, and its
+// sidecar goes to the kept copy. A copy under another name stays, and so does its sidecar
+func TestDupesKeepsOtherNames(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"granite/masters/20230101-001_granite_master.tif":     "frame",
+		"20230101-001_granite_master.tif":                     "frame",
+		"20230101-001_granite_master.tif.xmp":                 "stray sidecar",
+		"granite/masters/20230101-002_granite_master.tif":     "frame",
+		"granite/masters/20230101-002_granite_master.tif.xmp": "other sidecar",
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	answerStdin(t, "y\n")
+	err := dupes(dir, true, false, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		".":               "",
+		"granite":         "",
+		"granite/masters": "",
+		"granite/masters/20230101-001_granite_master.tif":     "frame",
+		"granite/masters/20230101-001_granite_master.tif.xmp": "stray sidecar",
+		"granite/masters/20230101-002_granite_master.tif":     "frame",
+		"granite/masters/20230101-002_granite_master.tif.xmp": "other sidecar",
+	}
+	if got := archiveContents(t, dir); !maps.Equal(got, want) {
+		t.Errorf("dupes left %v, expected %v", got, want)
+	}
+}
@@ -0,0 +1,259 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	boxes.go
+*/
+
+package exif
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"time"
+)
+
+/*
+	HEIC, AVIF and CR3 files are all built out of ISO base media boxes, each one a size and a four
+	letter type followed by its contents, often more boxes. HEIC and AVIF keep their EXIF data as
+	an item listed in the meta box, found by looking its id up in iinf and its location up in iloc.
+	CR3 keeps it in the CMT boxes inside a Canon uuid box in moov, each one a TIFF of its own.
+*/
+
+// The uuid Canon uses for the box holding a CR3's metadata
+const canonUUID = "85c0b687820f11e08111f4ce462b6a48"
+
+// Never read a box bigger than this into memory, a real item location box is a few hundred bytes
+// and a broken one could claim to be as big as the file
+const maxBoxRead = 1 << 20
+
+// A box's type and where its contents start and end
+type box struct {
+	kind  string
+	start int64
+	end   int64
+}
+
+// Lists the boxes between start and end
+func readBoxList(r io.ReaderAt, start, end int64) (boxes []box) {
+	pos := start
+	for pos+8 <= end {
+		header := make([]byte, 16)
+		n, _ := r.ReadAt(header, pos)
+		if n < 8 {
+			break
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		kind := string(header[4:8])
+		headerSize := int64(8)
+
+		switch size {
+		case 0: // The box runs to the end
+			size = end - pos
+		case 1: // The size didn't fit in 32 bits
+			if n < 16 {
+				return
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+
+		if size < headerSize || pos+size > end {
+			break
+		}
+
+		boxes = append(boxes, box{kind: kind, start: pos + headerSize, end: pos + size})
+		pos += size
+	}
+	return
+}
+
+func findBox(boxes []box, kind string) (box, bool) {
+	for _, b := range boxes {
+		if b.kind == kind {
+			return b, true
+		}
+	}
+	return box{}, false
+}
+
+// Finds the date in a HEIC, AVIF or CR3 file
+func readBoxes(r io.ReaderAt, size int64) (time.Time, error) {
+	top := readBoxList(r, 0, size)
+
+	// HEIC and AVIF
+	if meta, ok := findBox(top, "meta"); ok {
+		// meta is a full box, so it has 4 bytes of version and flags before its children
+		if date, err := readMetaExif(r, readBoxList(r, meta.start+4, meta.end)); err == nil {
+			return date, nil
+		}
+	}
+
+	// CR3
+	if moov, ok := findBox(top, "moov"); ok {
+		for _, b := range readBoxList(r, moov.start, moov.end) {
+			if b.kind != "uuid" {
+				continue
+			}
+
+			id := make([]byte, 16)
+			if _, err := r.ReadAt(id, b.start); err != nil || hex.EncodeToString(id) != canonUUID {
+				continue
+			}
+
+			// CMT2 holds the EXIF IFD and CMT1 holds IFD0, so try them in that order
+			children := readBoxList(r, b.start+16, b.end)
+			for _, kind := range []string{"CMT2", "CMT1"} {
+				if cmt, ok := findBox(children, kind); ok {
+					if date, err := readTIFF(r, cmt.start); err == nil {
+						return date, nil
+					}
+				}
+			}
+		}
+	}
+
+	return time.Time{}, ErrNoDate
+}
+
+// Finds the Exif item listed in a meta box and reads the TIFF inside it
+func readMetaExif(r io.ReaderAt, children []box) (time.Time, error) {
+	iinf, ok := findBox(children, "iinf")
+	if !ok {
+		return time.Time{}, ErrNoDate
+	}
+	iloc, ok := findBox(children, "iloc")
+	if !ok {
+		return time.Time{}, ErrNoDate
+	}
+
+	id, ok := findExifItem(r, iinf)
+	if !ok {
+		return time.Time{}, ErrNoDate
+	}
+
+	offset, ok := findItemOffset(r, iloc, id)
+	if !ok {
+		return time.Time{}, ErrNoDate
+	}
+
+	// The item starts with the offset from the end of these 4 bytes to the TIFF header
+	skip := make([]byte, 4)
+	if _, err := r.ReadAt(skip, offset); err != nil {
+		return time.Time{}, ErrNoDate
+	}
+	return readTIFF(r, offset+4+int64(binary.BigEndian.Uint32(skip)))
+}
+
+// Looks through the item info box for the id of the item with the type Exif
+func findExifItem(r io.ReaderAt, iinf box) (uint32, bool) {
+	version := make([]byte, 1)
+	if _, err := r.ReadAt(version, iinf.start); err != nil {
+		return 0, false
+	}
+
+	// The entry count is 16 bits in version 0 and 32 bits after that
+	start := iinf.start + 4 + 2
+	if version[0] > 0 {
+		start += 2
+	}
+
+	for _, infe := range readBoxList(r, start, iinf.end) {
+		if infe.kind != "infe" {
+			continue
+		}
+
+		data := make([]byte, 16)
+		n, _ := r.ReadAt(data, infe.start)
+		if n < 12 {
+			continue
+		}
+
+		// Only versions 2 and 3 have an item type, with a 16 and a 32 bit id respectively
+		switch data[0] {
+		case 2:
+			if string(data[8:12]) == "Exif" {
+				return uint32(binary.BigEndian.Uint16(data[4:6])), true
+			}
+		case 3:
+			if n >= 14 && string(data[10:14]) == "Exif" {
+				return binary.BigEndian.Uint32(data[4:8]), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// Looks through the item location box for where an item's first extent starts in the file
+func findItemOffset(r io.ReaderAt, iloc box, id uint32) (int64, bool) {
+	if iloc.end-iloc.start > maxBoxRead {
+		return 0, false
+	}
+	data := make([]byte, iloc.end-iloc.start)
+	if _, err := r.ReadAt(data, iloc.start); err != nil || len(data) < 8 {
+		return 0, false
+	}
+
+	version := data[0]
+	offsetSize := int(data[4] >> 4)
+	lengthSize := int(data[4] & 0x0F)
+	baseOffsetSize := int(data[5] >> 4)
+	indexSize := 0
+	if version == 1 || version == 2 {
+		indexSize = int(data[5] & 0x0F)
+	}
+
+	pos := 6
+	// Reads a big endian number of 0, 4 or 8 bytes and moves past it
+	read := func(size int) (uint64, bool) {
+		if pos+size > len(data) {
+			return 0, false
+		}
+		var value uint64
+		for _, b := range data[pos : pos+size] {
+			value = value<<8 | uint64(b)
+		}
+		pos += size
+		return value, true
+	}
+
+	idSize := 2
+	if version == 2 {
+		idSize = 4
+	}
+	itemCount, ok := read(idSize)
+	if !ok {
+		return 0, false
+	}
+
+	for i := uint64(0); i < itemCount; i++ {
+		itemID, _ := read(idSize)
+		if version == 1 || version == 2 {
+			read(2) // Construction method
+		}
+		read(2) // Data reference index
+		baseOffset, _ := read(baseOffsetSize)
+		extentCount, ok := read(2)
+		if !ok {
+			return 0, false
+		}
+
+		// Every extent takes the same room, so only the first has to be read to skip them all
+		extentSize := indexSize + offsetSize + lengthSize
+		if uint64(len(data)-pos) < extentCount*uint64(extentSize) {
+			return 0, false
+		}
+		extents := pos
+		read(indexSize)
+		firstOffset, _ := read(offsetSize)
+		pos = extents + int(extentCount)*extentSize
+
+		if uint32(itemID) == id && extentCount > 0 {
+			return int64(baseOffset + firstOffset), true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,260 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	exif.go
+*/
+
+// Package exif reads the date a photograph was taken out of the metadata embedded in the file,
+// and the JPEG preview embedded in raw files. It only knows as much of EXIF, TIFF, JPEG and the
+// HEIC/CR3 box format as it takes to find those, so it stays small and has no dependencies.
+package exif
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Returned when a file has no date in its metadata, or is in a format this package can't read
+var ErrNoDate = errors.New("no date found in the file's metadata")
+
+// The TIFF tags that hold dates. DateTimeOriginal is when the shutter fired, DateTime is when the
+// file was last changed by the camera or software and is only used if there is nothing better
+const (
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+)
+
+// Never read more than this many entries from one IFD, so a broken file can't run us in circles
+const maxEntries = 1000
+
+// Reads the date a file was taken from its metadata
+func DateTakenFile(path string) (time.Time, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+
+	stats, err := file.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return DateTaken(file, stats.Size())
+}
+
+// Reads the date a photograph was taken from its metadata. The format is worked out from the
+// first bytes of the file instead of its extension. JPEGs, TIFFs and the raw formats built on TIFF
+// (DNG, CR2, NEF, ARW, ORF, RW2, PEF and friends) are read directly, RAFs through their embedded
+// JPEG, and HEIC, AVIF and CR3 through their boxes. If the file stores OffsetTimeOriginal the
+// time is in that zone, otherwise it is the camera's clock read as local time.
+func DateTaken(r io.ReaderAt, size int64) (time.Time, error) {
+	head := make([]byte, 16)
+	n, _ := r.ReadAt(head, 0)
+	head = head[:n]
+
+	switch {
+	case len(head) >= 2 && head[0] == 0xFF && head[1] == 0xD8:
+		return readJPEG(r, 0, size)
+	case len(head) >= 4 && (string(head[:2]) == "II" || string(head[:2]) == "MM"):
+		return readTIFF(r, 0)
+	case len(head) >= 16 && string(head[:16]) == "FUJIFILMCCD-RAW ":
+		return readRAF(r, size)
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		return readBoxes(r, size)
+	}
+
+	return time.Time{}, ErrNoDate
+}
+
+// Walks the segments of a JPEG looking for the APP1 segment that holds the EXIF data
+func readJPEG(r io.ReaderAt, start, size int64) (time.Time, error) {
+	pos := start + 2
+	for pos+4 <= size {
+		header := make([]byte, 4)
+		if _, err := r.ReadAt(header, pos); err != nil {
+			break
+		}
+		if header[0] != 0xFF {
+			break
+		}
+
+		// Skip padding bytes between segments
+		if header[1] == 0xFF {
+			pos++
+			continue
+		}
+
+		// The image data starts at SOS, and no metadata comes after it
+		marker := header[1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+
+		length := int64(binary.BigEndian.Uint16(header[2:4]))
+		if marker == 0xE1 && length >= 8 {
+			id := make([]byte, 6)
+			if _, err := r.ReadAt(id, pos+4); err == nil && string(id) == "Exif\x00\x00" {
+				return readTIFF(r, pos+10)
+			}
+		}
+
+		pos += 2 + length
+	}
+
+	return time.Time{}, ErrNoDate
+}
+
+// RAF files start with a fixed header pointing at a JPEG preview that carries the EXIF data
+func readRAF(r io.ReaderAt, size int64) (time.Time, error) {
+	pointer := make([]byte, 4)
+	if _, err := r.ReadAt(pointer, 84); err != nil {
+		return time.Time{}, ErrNoDate
+	}
+
+	offset := int64(binary.BigEndian.Uint32(pointer))
+	if offset <= 0 || offset >= size {
+		return time.Time{}, ErrNoDate
+	}
+	return readJPEG(r, offset, size)
+}
+
+// One entry of a TIFF image file directory
+type entry struct {
+	tag    uint16
+	kind   uint16
+	count  uint32
+	value  []byte // The raw 4 byte value or offset
+	offset int64  // Where the value lives when it doesn't fit in 4 bytes
+}
+
+// A TIFF structure starting at base. Every offset in it is relative to base
+type tiff struct {
+	r     io.ReaderAt
+	base  int64
+	order binary.ByteOrder
+}
+
+// Reads the dates out of a TIFF structure, checking IFD0 and the EXIF IFD it points to
+func readTIFF(r io.ReaderAt, base int64) (time.Time, error) {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, base); err != nil {
+		return time.Time{}, ErrNoDate
+	}
+
+	t := tiff{r: r, base: base}
+	switch string(header[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return time.Time{}, ErrNoDate
+	}
+
+	// The magic number is 42 for a real TIFF, but Olympus and Panasonic raws use their own
+	magic := t.order.Uint16(header[2:4])
+	if magic != 42 && magic != 0x4F52 && magic != 0x5352 && magic != 0x55 {
+		return time.Time{}, ErrNoDate
+	}
+
+	tags := make(map[uint16]entry)
+	ifd0 := t.readIFD(int64(t.order.Uint32(header[4:8])))
+	for _, e := range ifd0 {
+		tags[e.tag] = e
+	}
+
+	if pointer, ok := tags[tagExifIFD]; ok {
+		for _, e := range t.readIFD(int64(t.order.Uint32(pointer.value))) {
+			tags[e.tag] = e
+		}
+	}
+
+	// Prefer when the photograph was taken over when the file was written
+	for _, tag := range []uint16{tagDateTimeOriginal, tagDateTime} {
+		e, ok := tags[tag]
+		if !ok {
+			continue
+		}
+
+		offset := ""
+		if tag == tagDateTimeOriginal {
+			if o, ok := tags[tagOffsetTimeOriginal]; ok {
+				offset = t.readString(o)
+			}
+		}
+
+		date, err := parseDate(t.readString(e), offset)
+		if err == nil {
+			return date, nil
+		}
+	}
+
+	return time.Time{}, ErrNoDate
+}
+
+// Reads every entry of the IFD at an offset, returning nothing if it can't be read
+func (t tiff) readIFD(offset int64) (entries []entry) {
+	countBytes := make([]byte, 2)
+	if _, err := t.r.ReadAt(countBytes, t.base+offset); err != nil {
+		return nil
+	}
+
+	count := int(t.order.Uint16(countBytes))
+	if count > maxEntries {
+		return nil
+	}
+
+	data := make([]byte, count*12)
+	if _, err := t.r.ReadAt(data, t.base+offset+2); err != nil {
+		return nil
+	}
+
+	for i := 0; i < count; i++ {
+		raw := data[i*12 : i*12+12]
+		entries = append(entries, entry{
+			tag:    t.order.Uint16(raw[0:2]),
+			kind:   t.order.Uint16(raw[2:4]),
+			count:  t.order.Uint32(raw[4:8]),
+			value:  raw[8:12],
+			offset: int64(t.order.Uint32(raw[8:12])),
+		})
+	}
+	return
+}
+
+// Reads an ASCII entry, which is stored in the entry itself if it is 4 bytes or shorter
+func (t tiff) readString(e entry) string {
+	const typeASCII = 2
+	if e.kind != typeASCII || e.count == 0 || e.count > 64 {
+		return ""
+	}
+
+	data := e.value
+	if e.count > 4 {
+		data = make([]byte, e.count)
+		if _, err := t.r.ReadAt(data, t.base+e.offset); err != nil {
+			return ""
+		}
+	}
+
+	return strings.TrimRight(string(data[:min(int(e.count), len(data))]), "\x00 ")
+}
+
+// Parses an EXIF date like "2024:12:01 14:03:59", with an optional offset like "+01:00"
+// A blank or broken offset is ignored rather than throwing away a good date
+func parseDate(date, offset string) (time.Time, error) {
+	if offset != "" {
+		t, err := time.Parse("2006:01:02 15:04:05-07:00", date+offset)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.ParseInLocation("2006:01:02 15:04:05", date, time.Local)
+}
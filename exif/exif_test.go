@@ -0,0 +1,308 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	exif_test.go
+*/
+
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+/*
+	The files here are built by hand, holding just enough of each format for this package to find
+	a date or a preview in, so they can be cut short or broken in exactly the places that matter.
+*/
+
+// One entry of an IFD being built
+type field struct {
+	tag   uint16
+	kind  uint16
+	count uint32
+	value uint32 // Used when there is no data
+	data  []byte // Put after the IFDs when it doesn't fit in the entry
+}
+
+func ascii(tag uint16, s string) field {
+	data := append([]byte(s), 0)
+	return field{tag: tag, kind: 2, count: uint32(len(data)), data: data}
+}
+
+func long(tag uint16, value uint32) field {
+	return field{tag: tag, kind: 4, count: 1, value: value}
+}
+
+// Builds a TIFF out of a chain of IFDs, each one pointing at the next
+func buildTIFF(order binary.ByteOrder, ifds ...[]field) []byte {
+	size := 8
+	for _, ifd := range ifds {
+		size += 2 + 12*len(ifd) + 4
+	}
+
+	out := make([]byte, 8)
+	if order == binary.LittleEndian {
+		copy(out, "II")
+	} else {
+		copy(out, "MM")
+	}
+	order.PutUint16(out[2:], 42)
+	order.PutUint32(out[4:], 8)
+
+	var extra []byte
+	for i, ifd := range ifds {
+		entries := make([]byte, 2+12*len(ifd)+4)
+		order.PutUint16(entries, uint16(len(ifd)))
+		for j, f := range ifd {
+			e := entries[2+12*j:]
+			order.PutUint16(e[0:], f.tag)
+			order.PutUint16(e[2:], f.kind)
+			order.PutUint32(e[4:], f.count)
+			switch {
+			case len(f.data) > 4:
+				order.PutUint32(e[8:], uint32(size+len(extra)))
+				extra = append(extra, f.data...)
+			case f.data != nil:
+				copy(e[8:12], f.data)
+			default:
+				order.PutUint32(e[8:], f.value)
+			}
+		}
+		if i < len(ifds)-1 {
+			order.PutUint32(entries[len(entries)-4:], uint32(len(out)+len(entries)))
+		}
+		out = append(out, entries...)
+	}
+	return append(out, extra...)
+}
+
+// A TIFF with DateTime in IFD0 and DateTimeOriginal with its offset in the EXIF IFD
+func datedTIFF(order binary.ByteOrder) []byte {
+	exifIFD := uint32(8 + 2 + 12*2 + 4)
+	return buildTIFF(order,
+		[]field{ascii(tagDateTime, "2020:01:01 00:00:00"), long(tagExifIFD, exifIFD)},
+		[]field{ascii(tagDateTimeOriginal, "2023:06:15 10:30:00"), ascii(tagOffsetTimeOriginal, "+02:00")},
+	)
+}
+
+// When datedTIFF says it was taken
+var datedTIFFTaken = time.Date(2023, 6, 15, 8, 30, 0, 0, time.UTC)
+
+// A JPEG with a TIFF in its APP1 segment, which a browser could draw
+func buildJPEG(tiff []byte) []byte {
+	out := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	out = binary.BigEndian.AppendUint16(out, uint16(2+6+len(tiff)))
+	out = append(out, "Exif\x00\x00"...)
+	out = append(out, tiff...)
+	return append(out, 0xFF, 0xC0, 0x00, 0x04, 0x00, 0x00, 0xFF, 0xD9)
+}
+
+// The smallest JPEG drawableJPEG accepts
+var smallJPEG = []byte{0xFF, 0xD8, 0xFF, 0xC0, 0x00, 0x04, 0x00, 0x00, 0xFF, 0xD9}
+
+// A RAF header pointing at a JPEG right after it
+func buildRAF(jpeg []byte) []byte {
+	out := make([]byte, 100)
+	copy(out, "FUJIFILMCCD-RAW ")
+	binary.BigEndian.PutUint32(out[84:], 100)
+	binary.BigEndian.PutUint32(out[88:], uint32(len(jpeg)))
+	return append(out, jpeg...)
+}
+
+func buildBox(kind string, contents ...[]byte) []byte {
+	var body []byte
+	for _, c := range contents {
+		body = append(body, c...)
+	}
+	out := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	out = append(out, kind...)
+	return append(out, body...)
+}
+
+// A box with a version and flags before its contents
+func fullBox(kind string, version byte, contents ...[]byte) []byte {
+	return buildBox(kind, append([][]byte{{version, 0, 0, 0}}, contents...)...)
+}
+
+func uuid(id string) []byte {
+	data, _ := hex.DecodeString(id)
+	return data
+}
+
+// A HEIC whose meta box lists a single Exif item kept in mdat
+func buildHEIC(tiff []byte) []byte {
+	ftyp := buildBox("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	infe := fullBox("infe", 2, []byte{0x00, 0x01, 0x00, 0x00}, []byte("Exif"), []byte{0})
+	iinf := fullBox("iinf", 0, []byte{0x00, 0x01}, infe)
+	meta := func(offset uint32) []byte {
+		// 4 byte offsets and lengths, one item with id 1 and one extent
+		iloc := []byte{0x44, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01}
+		iloc = binary.BigEndian.AppendUint32(iloc, offset)
+		iloc = binary.BigEndian.AppendUint32(iloc, uint32(4+len(tiff)))
+		return fullBox("meta", 0, iinf, fullBox("iloc", 0, iloc))
+	}
+
+	offset := uint32(len(ftyp) + len(meta(0)) + 8)
+	item := append([]byte{0, 0, 0, 0}, tiff...)
+	out := append(ftyp, meta(offset)...)
+	return append(out, buildBox("mdat", item)...)
+}
+
+// A CR3 with the TIFF in CMT1 and a JPEG in PRVW
+func buildCR3(tiff, jpeg []byte) []byte {
+	ftyp := buildBox("ftyp", []byte("crx \x00\x00\x00\x01crx isom"))
+	moov := buildBox("moov", buildBox("uuid", uuid(canonUUID), buildBox("CMT1", tiff)))
+
+	header := make([]byte, 16)
+	binary.BigEndian.PutUint32(header[12:], uint32(len(jpeg)))
+	preview := buildBox("uuid", uuid(canonPreviewUUID), make([]byte, 8), buildBox("PRVW", header, jpeg))
+
+	return append(append(ftyp, moov...), preview...)
+}
+
+// A reader that claims to be far bigger than it is and remembers the biggest read asked of it
+type hugeReader struct {
+	data    []byte
+	biggest int
+}
+
+func (h *hugeReader) ReadAt(p []byte, off int64) (int, error) {
+	h.biggest = max(h.biggest, len(p))
+	if off >= int64(len(h.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, h.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func TestDateTaken(t *testing.T) {
+	local := time.Date(2021, 1, 2, 3, 4, 5, 0, time.Local)
+	tests := []struct {
+		name string
+		data []byte
+		want time.Time
+	}{
+		{"little endian tiff", datedTIFF(binary.LittleEndian), datedTIFFTaken},
+		{"big endian tiff", datedTIFF(binary.BigEndian), datedTIFFTaken},
+		{"tiff with only DateTime", buildTIFF(binary.BigEndian, []field{ascii(tagDateTime, "2021:01:02 03:04:05")}), local},
+		{"tiff with a broken offset", buildTIFF(binary.LittleEndian, []field{ascii(tagDateTimeOriginal, "2021:01:02 03:04:05"), ascii(tagOffsetTimeOriginal, "later")}), local},
+		{"jpeg", buildJPEG(datedTIFF(binary.LittleEndian)), datedTIFFTaken},
+		{"raf", buildRAF(buildJPEG(datedTIFF(binary.BigEndian))), datedTIFFTaken},
+		{"heic", buildHEIC(datedTIFF(binary.BigEndian)), datedTIFFTaken},
+		{"cr3", buildCR3(datedTIFF(binary.LittleEndian), smallJPEG), datedTIFFTaken},
+	}
+
+	for _, test := range tests {
+		got, err := DateTaken(bytes.NewReader(test.data), int64(len(test.data)))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("%s: got %v, expected %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDateTakenBroken(t *testing.T) {
+	// An IFD claiming far more entries than any camera writes
+	tooManyEntries := buildTIFF(binary.LittleEndian, []field{ascii(tagDateTime, "2021:01:02 03:04:05")})
+	binary.LittleEndian.PutUint16(tooManyEntries[8:], 0xFFFF)
+
+	// A date claiming to be 4 GB long
+	longDate := buildTIFF(binary.BigEndian, []field{{tag: tagDateTime, kind: 2, count: 0xFFFFFFFF, value: 8}})
+
+	// An EXIF IFD pointing back at IFD0
+	loop := buildTIFF(binary.LittleEndian, []field{long(tagExifIFD, 8)})
+
+	// An item location box with the most extents it can hold, all of them taking no room
+	ilocExtents := []byte{0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0xFF, 0xFF}
+	manyExtents := append(buildBox("ftyp", []byte("heic")), fullBox("meta", 0,
+		fullBox("iinf", 0, []byte{0x00, 0x01}, fullBox("infe", 2, []byte{0x00, 0x01, 0x00, 0x00}, []byte("Exif"))),
+		fullBox("iloc", 0, ilocExtents))...)
+
+	// A box with a 64 bit size bigger than the file
+	hugeBox := append(buildBox("ftyp", []byte("heic")), 0, 0, 0, 1, 'm', 'e', 't', 'a', 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+
+	// A RAF pointing past its own end
+	rafPastEnd := buildRAF(nil)
+	binary.BigEndian.PutUint32(rafPastEnd[84:], 0xFFFFFFF0)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not a photograph", []byte("just some text, nothing to see here")},
+		{"too many entries", tooManyEntries},
+		{"4 GB date", longDate},
+		{"exif ifd loop", loop},
+		{"many extents", manyExtents},
+		{"huge box", hugeBox},
+		{"raf past its end", rafPastEnd},
+		{"jpeg without exif", smallJPEG},
+	}
+
+	for _, test := range tests {
+		_, err := DateTaken(bytes.NewReader(test.data), int64(len(test.data)))
+		if !errors.Is(err, ErrNoDate) {
+			t.Errorf("%s: got %v, expected ErrNoDate", test.name, err)
+		}
+	}
+}
+
+// Every fixture cut short at every length has to give a date or ErrNoDate, and never panic
+func TestDateTakenTruncated(t *testing.T) {
+	fixtures := [][]byte{
+		datedTIFF(binary.LittleEndian),
+		buildJPEG(datedTIFF(binary.BigEndian)),
+		buildRAF(buildJPEG(datedTIFF(binary.LittleEndian))),
+		buildHEIC(datedTIFF(binary.LittleEndian)),
+		buildCR3(datedTIFF(binary.BigEndian), smallJPEG),
+	}
+
+	for _, fixture := range fixtures {
+		for n := range fixture {
+			_, err := DateTaken(bytes.NewReader(fixture[:n]), int64(n))
+			if err != nil && !errors.Is(err, ErrNoDate) {
+				t.Errorf("%d of %d bytes: got %v", n, len(fixture), err)
+			}
+		}
+	}
+}
+
+// Boxes that run to the end of the file can't make us read the whole file, however big it claims
+// to be
+func TestDateTakenBoundedReads(t *testing.T) {
+	// meta and iloc both have a size of 0, which means they run to the end of the file
+	open := append(buildBox("ftyp", []byte("heic")), 0, 0, 0, 0, 'm', 'e', 't', 'a', 0, 0, 0, 0)
+	open = append(open, 0, 0, 0, 0, 'i', 'i', 'n', 'f')
+	r := &hugeReader{data: open}
+
+	_, err := DateTaken(r, 1<<40)
+	if !errors.Is(err, ErrNoDate) {
+		t.Errorf("got %v, expected ErrNoDate", err)
+	}
+
+	withIloc := append(buildBox("ftyp", []byte("heic")), 0, 0, 0, 0, 'm', 'e', 't', 'a', 0, 0, 0, 0)
+	withIloc = append(withIloc, fullBox("iinf", 0, []byte{0x00, 0x01}, fullBox("infe", 2, []byte{0x00, 0x01, 0x00, 0x00}, []byte("Exif")))...)
+	withIloc = append(withIloc, 0, 0, 0, 0, 'i', 'l', 'o', 'c', 0, 0, 0, 0, 0x44, 0x00)
+	r = &hugeReader{data: withIloc}
+
+	_, err = DateTaken(r, 1<<40)
+	if !errors.Is(err, ErrNoDate) {
+		t.Errorf("got %v, expected ErrNoDate", err)
+	}
+	if r.biggest > maxBoxRead {
+		t.Errorf("read %d bytes at once from a %d byte file", r.biggest, len(withIloc))
+	}
+}
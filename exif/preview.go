@@ -0,0 +1,278 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	preview.go
+*/
+
+package exif
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+)
+
+/*
+	Raw files carry a JPEG preview made by the camera, so they can be shown without developing
+	them. The TIFF based raws point at theirs from an IFD, either with JPEGInterchangeFormat or
+	as a single JPEG compressed strip, and often keep several of different sizes in the IFD chain
+	and its SubIFDs. Panasonic keeps a whole JPEG in one tag of IFD0, Fujifilm points at one from
+	the RAF header and Canon keeps one in the PRVW box of a CR3. TIFFs written by editors often
+	have a small one too. The biggest preview a browser can draw is the one used.
+*/
+
+// Returned when a file has no embedded preview this package can find
+var ErrNoPreview = errors.New("no preview found in the file")
+
+const (
+	tagCompression      = 0x0103
+	tagStripOffsets     = 0x0111
+	tagStripByteCounts  = 0x0117
+	tagSubIFDs          = 0x014A
+	tagJPEGOffset       = 0x0201
+	tagJPEGLength       = 0x0202
+	tagPanasonicJPEG    = 0x002E
+	compressionJPEG     = 6
+	compressionJPEGTech = 7
+)
+
+// Never follow more IFDs than this, so a broken file can't run us in circles
+const maxIFDs = 32
+
+// Never read a preview bigger than this, so a broken file can't make us read all of itself
+const maxPreviewSize = 64 << 20
+
+// The uuid Canon uses for the box holding a CR3's preview
+const canonPreviewUUID = "eaf42b5e1c984b88b9fbb7dc406e4d16"
+
+// Where a preview sits in the file
+type span struct {
+	offset int64
+	length int64
+}
+
+// Reads the biggest embedded JPEG preview out of a file
+func PreviewFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stats, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return Preview(file, stats.Size())
+}
+
+// Reads the biggest embedded JPEG preview out of a raw file or TIFF. Like DateTaken, the format is
+// worked out from the first bytes of the file
+func Preview(r io.ReaderAt, size int64) ([]byte, error) {
+	head := make([]byte, 16)
+	n, _ := r.ReadAt(head, 0)
+	head = head[:n]
+
+	var spans []span
+	switch {
+	case len(head) >= 4 && (string(head[:2]) == "II" || string(head[:2]) == "MM"):
+		spans = tiffPreviews(r, 0)
+	case len(head) >= 16 && string(head[:16]) == "FUJIFILMCCD-RAW ":
+		spans = rafPreviews(r)
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		spans = cr3Previews(r, size)
+	}
+
+	// Take the biggest preview that is really a JPEG a browser can draw
+	var best span
+	for _, s := range spans {
+		if s.length > best.length && s.length <= maxPreviewSize && s.offset+s.length <= size && drawableJPEG(r, s) {
+			best = s
+		}
+	}
+	if best.length == 0 {
+		return nil, ErrNoPreview
+	}
+
+	data := make([]byte, best.length)
+	if _, err := r.ReadAt(data, best.offset); err != nil {
+		return nil, errors.Join(ErrNoPreview, err)
+	}
+	return data, nil
+}
+
+// Finds every JPEG an IFD chain points at, along with the chains in its SubIFDs
+func tiffPreviews(r io.ReaderAt, base int64) (spans []span) {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, base); err != nil {
+		return nil
+	}
+
+	t := tiff{r: r, base: base}
+	switch string(header[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil
+	}
+
+	queue := []int64{int64(t.order.Uint32(header[4:8]))}
+	seen := make(map[int64]bool)
+	for len(queue) > 0 && len(seen) < maxIFDs {
+		offset := queue[0]
+		queue = queue[1:]
+		if offset <= 0 || seen[offset] {
+			continue
+		}
+		seen[offset] = true
+
+		entries := t.readIFD(offset)
+		tags := make(map[uint16]entry)
+		for _, e := range entries {
+			tags[e.tag] = e
+		}
+
+		if start, ok := tags[tagJPEGOffset]; ok {
+			if length, ok := tags[tagJPEGLength]; ok {
+				spans = append(spans, span{t.base + t.readUint(start), t.readUint(length)})
+			}
+		}
+
+		// A single strip compressed as a JPEG is a preview too
+		if compression, ok := tags[tagCompression]; ok {
+			kind := t.readUint(compression)
+			start, hasStart := tags[tagStripOffsets]
+			length, hasLength := tags[tagStripByteCounts]
+			if (kind == compressionJPEG || kind == compressionJPEGTech) && hasStart && hasLength && start.count == 1 {
+				spans = append(spans, span{t.base + t.readUint(start), t.readUint(length)})
+			}
+		}
+
+		if e, ok := tags[tagPanasonicJPEG]; ok && e.count > 4 {
+			spans = append(spans, span{t.base + e.offset, int64(e.count)})
+		}
+
+		if e, ok := tags[tagSubIFDs]; ok {
+			queue = append(queue, t.readOffsets(e)...)
+		}
+
+		// The offset of the next IFD in the chain comes right after the entries
+		next := make([]byte, 4)
+		if _, err := r.ReadAt(next, t.base+offset+2+int64(len(entries))*12); err == nil {
+			queue = append(queue, int64(t.order.Uint32(next)))
+		}
+	}
+	return spans
+}
+
+// The RAF header holds the offset and length of its JPEG
+func rafPreviews(r io.ReaderAt) []span {
+	pointer := make([]byte, 8)
+	if _, err := r.ReadAt(pointer, 84); err != nil {
+		return nil
+	}
+	return []span{{int64(binary.BigEndian.Uint32(pointer[0:4])), int64(binary.BigEndian.Uint32(pointer[4:8]))}}
+}
+
+// A CR3 keeps its preview in a PRVW box inside a Canon uuid box at the top level
+func cr3Previews(r io.ReaderAt, size int64) (spans []span) {
+	for _, b := range readBoxList(r, 0, size) {
+		if b.kind != "uuid" {
+			continue
+		}
+
+		id := make([]byte, 16)
+		if _, err := r.ReadAt(id, b.start); err != nil || hex.EncodeToString(id) != canonPreviewUUID {
+			continue
+		}
+
+		// The uuid is followed by 8 bytes before the PRVW box
+		for _, prvw := range readBoxList(r, b.start+24, b.end) {
+			if prvw.kind != "PRVW" {
+				continue
+			}
+
+			// The JPEG comes after a small header with its size in it
+			header := make([]byte, 16)
+			if _, err := r.ReadAt(header, prvw.start); err != nil {
+				continue
+			}
+			length := int64(binary.BigEndian.Uint32(header[12:16]))
+			spans = append(spans, span{prvw.start + 16, min(length, prvw.end-prvw.start-16)})
+		}
+	}
+	return spans
+}
+
+// True if a span starts a JPEG that isn't lossless, which is how raw sensor data is often stored
+// and which no browser can draw
+func drawableJPEG(r io.ReaderAt, s span) bool {
+	pos := s.offset
+	end := s.offset + s.length
+	marker := make([]byte, 4)
+	if _, err := r.ReadAt(marker[:2], pos); err != nil || marker[0] != 0xFF || marker[1] != 0xD8 {
+		return false
+	}
+
+	pos += 2
+	for pos+4 <= end {
+		if _, err := r.ReadAt(marker, pos); err != nil || marker[0] != 0xFF {
+			return false
+		}
+
+		switch marker[1] {
+		case 0xFF:
+			pos++
+			continue
+		case 0xC0, 0xC1, 0xC2: // Baseline, extended and progressive
+			return true
+		case 0xC3, 0xC5, 0xC6, 0xC7, 0xC9, 0xCA, 0xCB, 0xCD, 0xCE, 0xCF, 0xDA, 0xD9:
+			return false
+		}
+
+		pos += 2 + int64(binary.BigEndian.Uint16(marker[2:4]))
+	}
+	return false
+}
+
+// Reads a SHORT or LONG entry holding a single number
+func (t tiff) readUint(e entry) int64 {
+	const (
+		typeShort = 3
+		typeLong  = 4
+	)
+
+	switch e.kind {
+	case typeShort:
+		return int64(t.order.Uint16(e.value[0:2]))
+	case typeLong:
+		return int64(t.order.Uint32(e.value))
+	}
+	return 0
+}
+
+// Reads a list of IFD offsets, which is stored in the entry itself if there is only one
+func (t tiff) readOffsets(e entry) []int64 {
+	if e.count == 0 || e.count > maxIFDs {
+		return nil
+	}
+	if e.count == 1 {
+		return []int64{int64(t.order.Uint32(e.value))}
+	}
+
+	data := make([]byte, e.count*4)
+	if _, err := t.r.ReadAt(data, t.base+e.offset); err != nil {
+		return nil
+	}
+
+	offsets := make([]int64, e.count)
+	for i := range offsets {
+		offsets[i] = int64(t.order.Uint32(data[i*4:]))
+	}
+	return offsets
+}
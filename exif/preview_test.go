@@ -0,0 +1,138 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	preview_test.go
+*/
+
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// A TIFF pointing at a JPEG right after it with JPEGInterchangeFormat
+func previewTIFF(order binary.ByteOrder, jpeg []byte) []byte {
+	build := func(offset uint32) []byte {
+		return buildTIFF(order, []field{long(tagJPEGOffset, offset), long(tagJPEGLength, uint32(len(jpeg)))})
+	}
+	tiff := build(uint32(len(build(0))))
+	return append(tiff, jpeg...)
+}
+
+// A JPEG bigger than smallJPEG, so it is the one picked when both are there
+var biggerJPEG = []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x04, 0x00, 0x00, 0xFF, 0xC2, 0x00, 0x04, 0x00, 0x00, 0xFF, 0xD9}
+
+func TestPreview(t *testing.T) {
+	// A TIFF with a small preview in IFD0 and a bigger one as a JPEG compressed strip in a SubIFD
+	strips := func(offset uint32) []byte {
+		subIFD := uint32(8 + 2 + 12*3 + 4)
+		return buildTIFF(binary.BigEndian,
+			[]field{long(tagJPEGOffset, offset), long(tagJPEGLength, uint32(len(smallJPEG))), long(tagSubIFDs, subIFD)},
+			[]field{long(tagCompression, compressionJPEG), long(tagStripOffsets, offset+uint32(len(smallJPEG))), long(tagStripByteCounts, uint32(len(biggerJPEG)))},
+		)
+	}
+	subIFDs := strips(uint32(len(strips(0))))
+	subIFDs = append(append(subIFDs, smallJPEG...), biggerJPEG...)
+
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{"little endian tiff", previewTIFF(binary.LittleEndian, smallJPEG), smallJPEG},
+		{"big endian tiff", previewTIFF(binary.BigEndian, biggerJPEG), biggerJPEG},
+		{"biggest of several", subIFDs, biggerJPEG},
+		{"raf", buildRAF(smallJPEG), smallJPEG},
+		{"cr3", buildCR3(datedTIFF(binary.LittleEndian), biggerJPEG), biggerJPEG},
+	}
+
+	for _, test := range tests {
+		got, err := Preview(bytes.NewReader(test.data), int64(len(test.data)))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("%s: got % x, expected % x", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPreviewBroken(t *testing.T) {
+	// Lossless JPEGs are how raw data is often kept, and no browser can draw them
+	lossless := []byte{0xFF, 0xD8, 0xFF, 0xC3, 0x00, 0x04, 0x00, 0x00, 0xFF, 0xD9}
+
+	// A chain of IFDs where the next IFD is always IFD0, and a SubIFD pointing back at it too
+	loop := buildTIFF(binary.LittleEndian, []field{long(tagSubIFDs, 8)})
+	binary.LittleEndian.PutUint32(loop[len(loop)-4:], 8)
+
+	// A Panasonic preview claiming to be 4 GB
+	panasonic := buildTIFF(binary.LittleEndian, []field{{tag: tagPanasonicJPEG, kind: 7, count: 0xFFFFFFF0, value: 26}})
+	panasonic = append(panasonic, smallJPEG...)
+
+	// A preview pointing past the end of the file
+	pastEnd := previewTIFF(binary.BigEndian, smallJPEG)
+	pastEnd = pastEnd[:len(pastEnd)-2]
+
+	// A list of SubIFDs far longer than any camera writes
+	manySubIFDs := buildTIFF(binary.BigEndian, []field{{tag: tagSubIFDs, kind: 4, count: 0xFFFFFFFF, value: 8}})
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not a photograph", []byte("just some text, nothing to see here")},
+		{"plain jpeg", smallJPEG},
+		{"lossless", previewTIFF(binary.LittleEndian, lossless)},
+		{"ifd loop", loop},
+		{"4 GB panasonic preview", panasonic},
+		{"past the end", pastEnd},
+		{"many subifds", manySubIFDs},
+		{"raf without a preview", buildRAF(nil)},
+	}
+
+	for _, test := range tests {
+		_, err := Preview(bytes.NewReader(test.data), int64(len(test.data)))
+		if !errors.Is(err, ErrNoPreview) {
+			t.Errorf("%s: got %v, expected ErrNoPreview", test.name, err)
+		}
+	}
+}
+
+// Every fixture cut short at every length has to give a preview or ErrNoPreview, and never panic
+func TestPreviewTruncated(t *testing.T) {
+	fixtures := [][]byte{
+		previewTIFF(binary.LittleEndian, smallJPEG),
+		buildRAF(biggerJPEG),
+		buildCR3(datedTIFF(binary.BigEndian), smallJPEG),
+	}
+
+	for _, fixture := range fixtures {
+		for n := range fixture {
+			_, err := Preview(bytes.NewReader(fixture[:n]), int64(n))
+			if err != nil && !errors.Is(err, ErrNoPreview) {
+				t.Errorf("%d of %d bytes: got %v", n, len(fixture), err)
+			}
+		}
+	}
+}
+
+// A preview claiming to be bigger than any camera writes isn't read, however big the file claims
+// to be
+func TestPreviewBoundedReads(t *testing.T) {
+	panasonic := buildTIFF(binary.LittleEndian, []field{{tag: tagPanasonicJPEG, kind: 7, count: 0xFFFFFFF0, value: 26}})
+	panasonic = append(panasonic, smallJPEG...)
+	r := &hugeReader{data: panasonic}
+
+	_, err := Preview(r, 1<<40)
+	if !errors.Is(err, ErrNoPreview) {
+		t.Errorf("got %v, expected ErrNoPreview", err)
+	}
+	if r.biggest > maxPreviewSize {
+		t.Errorf("read %d bytes at once from a %d byte file", r.biggest, len(panasonic))
+	}
+}
@@ -0,0 +1,280 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	find.go
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/karlramberg/loupe/photo"
+)
+
+/*
+	A query is a list of terms that all have to match, like "group=granite version=print
+	date=2023". Each term is a field, = or !=, and one or more values separated by commas, any of
+	which can match. Dates and numbers also take ranges written from..to, where either end can be
+	left off. A date can be cut short to a year or a month, so 2023 is the whole year and
+	202306..202308 is June through August. Class, group, version and subversion take * and ? like
+	a shell would. "none" matches a photograph without a letter, class or subversion.
+*/
+
+// The fields a query can look at
+var queryFields = []string{"date", "letter", "number", "class", "group", "version", "subversion", "ext"}
+
+// One term of a query
+type Condition struct {
+	field  string
+	negate bool
+	values []string
+}
+
+type Query []Condition
+
+// Parses the terms of a query, checking every value so a typo doesn't quietly match nothing
+func parseQuery(terms []string) (Query, error) {
+	var query Query
+	var problems []error
+	for _, term := range terms {
+		if strings.HasPrefix(term, "-") {
+			return nil, errors.New("\"" + term + "\" looks like a flag, put flags before the query")
+		}
+
+		field, value, ok := strings.Cut(term, "=")
+		if !ok {
+			problems = append(problems, errors.New("\""+term+"\" isn't formatted field=value"))
+			continue
+		}
+
+		condition := Condition{field: strings.ToLower(field)}
+		if strings.HasSuffix(condition.field, "!") {
+			condition.field = strings.TrimSuffix(condition.field, "!")
+			condition.negate = true
+		}
+		if !slices.Contains(queryFields, condition.field) {
+			problems = append(problems, errors.New("\""+field+"\" isn't a field, use one of "+strings.Join(queryFields, ", ")))
+			continue
+		}
+
+		for _, value := range strings.Split(value, ",") {
+			err := checkQueryValue(condition.field, value)
+			if err != nil {
+				problems = append(problems, errors.Join(errors.New("\""+term+"\" has an invalid value"), err))
+			}
+			condition.values = append(condition.values, value)
+		}
+		query = append(query, condition)
+	}
+
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+	return query, nil
+}
+
+// Checks one value of a term, which may be a range for dates and numbers
+func checkQueryValue(field, value string) error {
+	switch field {
+	case "date", "number":
+		from, to, isRange := strings.Cut(value, "..")
+		if isRange && from == "" && to == "" {
+			return errors.New("a range needs at least one end")
+		}
+
+		pattern := "^[0-9]+$"
+		reason := "numbers are whole numbers like 7 or 007"
+		if field == "date" {
+			pattern = "^([0-9]{4}|[0-9]{6}|[0-9]{8})$"
+			reason = "dates are formatted YYYY, YYYYMM or YYYYMMDD"
+		}
+		for _, end := range []string{from, to} {
+			if valid, _ := regexp.MatchString(pattern, end); end != "" && !valid {
+				return errors.New(reason)
+			}
+		}
+
+	case "letter":
+		if value == "none" {
+			return nil
+		}
+		if valid, _ := regexp.MatchString("^[A-Za-z]+$", value); !valid {
+			return errors.New("roll letters are letters, or none")
+		}
+
+	case "ext":
+		if valid, _ := regexp.MatchString("^\\.?[A-Za-z0-9]+$", value); !valid {
+			return errors.New("extensions are like tif or .tif")
+		}
+
+	default:
+		if _, err := path.Match(value, ""); err != nil || value == "" {
+			return errors.New("use lowercase letters and digits, * and ?")
+		}
+	}
+	return nil
+}
+
+// True if the photograph passes every term of the query
+func (q Query) matches(photograph photo.Photograph) bool {
+	for _, condition := range q {
+		if condition.matches(photograph) == condition.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// True if any value of the term matches the photograph, ignoring whether it is negated
+func (c Condition) matches(photograph photo.Photograph) bool {
+	for _, value := range c.values {
+		switch c.field {
+		case "date":
+			if inRange(value, photograph.Date, compareDates) {
+				return true
+			}
+		case "letter":
+			if strings.EqualFold(value, photograph.Letter) {
+				return true
+			}
+		case "number":
+			if inRange(value, photograph.Number, compareNumbers) {
+				return true
+			}
+		case "ext":
+			if strings.EqualFold(strings.TrimPrefix(value, "."), strings.TrimPrefix(photograph.Extension, ".")) {
+				return true
+			}
+		default:
+			attribute := map[string]string{
+				"class":      photograph.Class,
+				"group":      photograph.Group,
+				"version":    photograph.Version,
+				"subversion": photograph.Subversion,
+			}[c.field]
+			if matched, _ := path.Match(value, attribute); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// True if a value falls in a range like from..to, or matches it when it isn't a range. Compare
+// returns how the value sits against one end of the range
+func inRange(query, value string, compare func(value, end string) int) bool {
+	from, to, isRange := strings.Cut(query, "..")
+	if !isRange {
+		return compare(value, query) == 0
+	}
+	return (from == "" || compare(value, from) >= 0) && (to == "" || compare(value, to) <= 0)
+}
+
+// Compares a date to a date that may be cut short to a year or month, so 20230615 is equal to 2023
+func compareDates(date, end string) int {
+	return strings.Compare(date[:min(len(date), len(end))], end)
+}
+
+// Compares numbers by their value, so 7 and 007 are the same
+func compareNumbers(number, end string) int {
+	a, _ := strconv.Atoi(number)
+	b, _ := strconv.Atoi(end)
+	return a - b
+}
+
+// A file that matched a query, written out as JSON
+type FoundFile struct {
+	Path       string `json:"path"`
+	Identifier string `json:"identifier"`
+	Date       string `json:"date"`
+	Letter     string `json:"letter"`
+	Number     string `json:"number"`
+	Class      string `json:"class"`
+	Group      string `json:"group"`
+	Version    string `json:"version"`
+	Subversion string `json:"subversion"`
+	Extension  string `json:"extension"`
+}
+
+// Lists every validly named file in the archive that matches the query. Paths are printed one to a
+// line with nothing else, so they can be handed to other programs
+func find(dir string, terms []string, format string) error {
+	// Check that the -a flag was used
+	if dir == "" {
+		return errors.New("provide an archive directory using the -a flag")
+	}
+
+	// Check that the given directory exists
+	stats, err := os.Stat(dir)
+	if os.IsNotExist(err) || !stats.IsDir() {
+		return errors.New("directory \"" + dir + "\" not found")
+	}
+
+	// Check the query and format before doing any work
+	query, err := parseQuery(terms)
+	if err != nil {
+		return err
+	}
+	if format != "paths" && format != "table" && format != "json" {
+		return errors.New("invalid format. Use paths, table or json")
+	}
+
+	// Get a list of image files in the directory and its subdirectories, from the index if there is one
+	files, err := getArchiveFiles(dir, false)
+	if err != nil {
+		return errors.Join(errors.New("trouble getting image files from \""+dir+"\""), err)
+	}
+
+	found := []FoundFile{}
+	var paths []string
+	for _, file := range files {
+		if file.Err != nil || !query.matches(file.Photograph) {
+			continue
+		}
+
+		photograph := file.Photograph
+		found = append(found, FoundFile{
+			Path:       file.Path,
+			Identifier: photograph.Identifier(),
+			Date:       photograph.Date,
+			Letter:     photograph.Letter,
+			Number:     photograph.Number,
+			Class:      photograph.Class,
+			Group:      photograph.Group,
+			Version:    photograph.Version,
+			Subversion: photograph.Subversion,
+			Extension:  photograph.Extension,
+		})
+		paths = append(paths, file.Path)
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "\t")
+		err := encoder.Encode(found)
+		if err != nil {
+			return errors.Join(errors.New("trouble while writing the files found"), err)
+		}
+
+	case "table":
+		fmt.Println("Loupe", loupeVersion, "-", "Find")
+		fmt.Println(getFileTable(paths))
+		fmt.Println(len(paths), "file(s) found")
+
+	default:
+		for _, file := range paths {
+			fmt.Println(file)
+		}
+	}
+	return nil
+}
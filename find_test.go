@@ -0,0 +1,132 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	find_test.go
+*/
+
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/karlramberg/loupe/photo"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		terms []string
+		want  Query
+	}{
+		{[]string{"group=granite"}, Query{{field: "group", values: []string{"granite"}}}},
+		{[]string{"Version!=print,web"}, Query{{field: "version", negate: true, values: []string{"print", "web"}}}},
+		{[]string{"date=202306..202308", "number=..7"}, Query{
+			{field: "date", values: []string{"202306..202308"}},
+			{field: "number", values: []string{"..7"}},
+		}},
+		{[]string{"letter=none", "ext=.tif"}, Query{
+			{field: "letter", values: []string{"none"}},
+			{field: "ext", values: []string{".tif"}},
+		}},
+		{[]string{"class=tr?p", "subversion=8x*"}, Query{
+			{field: "class", values: []string{"tr?p"}},
+			{field: "subversion", values: []string{"8x*"}},
+		}},
+	}
+
+	for _, test := range tests {
+		got, err := parseQuery(test.terms)
+		if err != nil {
+			t.Errorf("parseQuery(%q) returned %v", test.terms, err)
+			continue
+		}
+		if !slices.EqualFunc(got, test.want, func(a, b Condition) bool {
+			return a.field == b.field && a.negate == b.negate && slices.Equal(a.values, b.values)
+		}) {
+			t.Errorf("parseQuery(%q) = %+v, expected %+v", test.terms, got, test.want)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := [][]string{
+		{"-json"},
+		{"granite"},
+		{"colour=red"},
+		{"date=2023-06"},
+		{"date=.."},
+		{"number=seven"},
+		{"letter=B2"},
+		{"ext=.t-f"},
+		{"group=[granite"},
+		{"group="},
+		{"group=granite", "number=x"},
+	}
+
+	for _, terms := range tests {
+		if _, err := parseQuery(terms); err == nil {
+			t.Errorf("parseQuery(%q) should have failed", terms)
+		}
+	}
+}
+
+func TestQueryMatches(t *testing.T) {
+	names := []string{
+		"20230101-001_granite_master.tif",
+		"20230102-005_granite_print-8x10.tif",
+		"20230615-B02_trip-berlin_master.tif",
+		"20230615-B03_trip-berlin_master.tif",
+		"20230615-B05_trip-berlin_web.jpg",
+		"20230615-B07_trip-berlin_master.tif",
+		"20230615-B08_trip-berlin_master.tif",
+		"20230615-B99100_trip-berlin_master.tif",
+		"20230701-A05_chalk_master.CR2",
+		"20240101-007_chalk_master.tif",
+	}
+
+	tests := []struct {
+		terms []string
+		want  []int // Indexes into names
+	}{
+		{[]string{"letter=B", "number=3..7"}, []int{3, 4, 5}},
+		{[]string{"letter=b", "number=100.."}, []int{7}},
+		{[]string{"letter=none"}, []int{0, 1, 9}},
+		{[]string{"number=5"}, []int{1, 4, 8}},
+		{[]string{"number=..2"}, []int{0, 2}},
+		{[]string{"date=2023"}, []int{0, 1, 2, 3, 4, 5, 6, 7, 8}},
+		{[]string{"date=202306..202307"}, []int{2, 3, 4, 5, 6, 7, 8}},
+		{[]string{"date=..20230102"}, []int{0, 1}},
+		{[]string{"group=granite,chalk", "version!=master"}, []int{1}},
+		{[]string{"class=none", "group=c*"}, []int{8, 9}},
+		{[]string{"subversion=8x?0"}, []int{1}},
+		{[]string{"ext=cr2"}, []int{8}},
+		{[]string{"ext=.jpg,tif", "letter!=B"}, []int{0, 1, 9}},
+	}
+
+	var photographs []photo.Photograph
+	for _, name := range names {
+		photograph, err := photo.Parse(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		photographs = append(photographs, photograph)
+	}
+
+	for _, test := range tests {
+		query, err := parseQuery(test.terms)
+		if err != nil {
+			t.Errorf("parseQuery(%q) returned %v", test.terms, err)
+			continue
+		}
+
+		var got []int
+		for i, photograph := range photographs {
+			if query.matches(photograph) {
+				got = append(got, i)
+			}
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%q matched %v, expected %v", test.terms, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	gaps.go
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/karlramberg/loupe/photo"
+)
+
+// An identifier that has a version it should be made from, but is missing the one that should be
+type Gap struct {
+	Identifier string   `json:"identifier"`
+	Versions   []string `json:"versions"` // Every version and subversion the identifier does have
+	Files      []string `json:"files"`
+}
+
+// Lists every identifier with a file of one of the have versions and no file of any of the missing
+// versions, to show what still needs editing. Without any have versions, every identifier counts
+func gaps(dir, have, missing, format string) error {
+	// Check that the -a flag was used
+	if dir == "" {
+		return errors.New("provide an archive directory using the -a flag")
+	}
+
+	// Check that the given directory exists
+	stats, err := os.Stat(dir)
+	if os.IsNotExist(err) || !stats.IsDir() {
+		return errors.New("directory \"" + dir + "\" not found")
+	}
+
+	// Check the versions and format before doing any work
+	if missing == "" {
+		return errors.New("provide the version that is missing using the -missing flag")
+	}
+	haveVersions, err := parseVersions(have)
+	if err != nil {
+		return errors.Join(errors.New("invalid -have version"), err)
+	}
+	missingVersions, err := parseVersions(missing)
+	if err != nil {
+		return errors.Join(errors.New("invalid -missing version"), err)
+	}
+	if format != "table" && format != "csv" && format != "json" {
+		return errors.New("invalid format. Use table, csv or json")
+	}
+
+	// Get a list of image files in the directory and its subdirectories, from the index if there is one
+	files, err := getArchiveFiles(dir, false)
+	if err != nil {
+		return errors.Join(errors.New("trouble getting image files from \""+dir+"\""), err)
+	}
+
+	// Group every validly named file by its identifier
+	photographs := make(map[string][]ScannedFile)
+	for _, file := range files {
+		if file.Err != nil {
+			continue
+		}
+		identifier := file.Photograph.Identifier()
+		photographs[identifier] = append(photographs[identifier], file)
+	}
+
+	found := []Gap{}
+	for identifier, group := range photographs {
+		hasOne := len(haveVersions) == 0
+		missesAll := true
+		gap := Gap{Identifier: identifier}
+		for _, file := range group {
+			if matchesVersion(file.Photograph, haveVersions) {
+				hasOne = true
+			}
+			if matchesVersion(file.Photograph, missingVersions) {
+				missesAll = false
+			}
+
+			version := joinOptional(file.Photograph.Version, file.Photograph.Subversion)
+			if !slices.Contains(gap.Versions, version) {
+				gap.Versions = append(gap.Versions, version)
+			}
+			gap.Files = append(gap.Files, file.Path)
+		}
+
+		if hasOne && missesAll {
+			slices.Sort(gap.Versions)
+			found = append(found, gap)
+		}
+	}
+
+	// Identifiers start with the date, so sorting them puts the backlog in order
+	slices.SortFunc(found, func(a, b Gap) int {
+		return strings.Compare(a.Identifier, b.Identifier)
+	})
+
+	switch format {
+	case "csv":
+		return writeGapsCSV(os.Stdout, found)
+	case "json":
+		return writeGapsJSON(os.Stdout, found)
+	}
+
+	fmt.Println("Loupe", loupeVersion, "-", "Gaps")
+	for _, gap := range found {
+		fmt.Println(gap.Identifier + "  has " + strings.Join(gap.Versions, ", "))
+		for _, file := range gap.Files {
+			fmt.Println("    " + file)
+		}
+	}
+	fmt.Println(len(found), "photograph(s) missing", missing)
+	return nil
+}
+
+// Parses a list of versions separated by commas. Each one is a version, or a version and a
+// subversion joined by - or /. Leaving out the version, like /crop, matches that subversion of
+// every version
+func parseVersions(list string) (versions [][2]string, err error) {
+	if list == "" {
+		return nil, nil
+	}
+
+	for _, version := range strings.Split(list, ",") {
+		name, subversion, hasSubversion := strings.Cut(version, "/")
+		if !hasSubversion {
+			name, subversion, hasSubversion = strings.Cut(version, "-")
+		}
+		if name != "" || !hasSubversion {
+			if valid, err := photo.ValidWord(name); !valid {
+				return nil, errors.Join(errors.New("\""+version+"\" has no valid version"), err)
+			}
+		}
+		if hasSubversion {
+			if valid, err := photo.ValidWord(subversion); !valid {
+				return nil, errors.Join(errors.New("\""+version+"\" has no valid subversion"), err)
+			}
+		}
+		versions = append(versions, [2]string{name, subversion})
+	}
+	return
+}
+
+// True if the photograph is any of the versions. A version without a subversion matches every
+// subversion of it, and a subversion without a version matches it in every version
+func matchesVersion(photograph photo.Photograph, versions [][2]string) bool {
+	for _, version := range versions {
+		if (version[0] == "" || photograph.Version == version[0]) && (version[1] == "" || photograph.Subversion == version[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Writes the gaps with a row for every file, so a path never has to share a field with another
+func writeGapsCSV(w io.Writer, found []Gap) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"identifier", "versions", "file"})
+	for _, gap := range found {
+		for _, file := range gap.Files {
+			writer.Write([]string{gap.Identifier, strings.Join(gap.Versions, " "), file})
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return errors.Join(errors.New("trouble while writing the gaps"), err)
+	}
+	return nil
+}
+
+// Writes the gaps as a JSON array
+func writeGapsJSON(w io.Writer, found []Gap) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	err := encoder.Encode(found)
+	if err != nil {
+		return errors.Join(errors.New("trouble while writing the gaps"), err)
+	}
+	return nil
+}
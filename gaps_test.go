@@ -0,0 +1,90 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	gaps_test.go
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"slices"
+	"testing"
+
+	"github.com/karlramberg/loupe/photo"
+)
+
+func TestMatchesVersion(t *testing.T) {
+	tests := []struct {
+		list    string
+		matches []string // Of master, print, print-8x10, print-crop and scan-crop
+	}{
+		{"master", []string{"master"}},
+		{"print", []string{"print", "print-8x10", "print-crop"}},
+		{"print-8x10", []string{"print-8x10"}},
+		{"print/8x10", []string{"print-8x10"}},
+		{"/crop", []string{"print-crop", "scan-crop"}},
+		{"master,/crop", []string{"master", "print-crop", "scan-crop"}},
+	}
+
+	all := []photo.Photograph{
+		{Version: "master", Subversion: photo.None},
+		{Version: "print", Subversion: photo.None},
+		{Version: "print", Subversion: "8x10"},
+		{Version: "print", Subversion: "crop"},
+		{Version: "scan", Subversion: "crop"},
+	}
+	for _, test := range tests {
+		versions, err := parseVersions(test.list)
+		if err != nil {
+			t.Errorf("parseVersions(%q) returned %v", test.list, err)
+			continue
+		}
+
+		var matches []string
+		for _, photograph := range all {
+			if matchesVersion(photograph, versions) {
+				matches = append(matches, joinOptional(photograph.Version, photograph.Subversion))
+			}
+		}
+		if !slices.Equal(matches, test.matches) {
+			t.Errorf("%q matched %v, expected %v", test.list, matches, test.matches)
+		}
+	}
+
+	for _, list := range []string{"/", "print/", "Print", "/8 x 10", "master,"} {
+		if _, err := parseVersions(list); err == nil {
+			t.Errorf("parseVersions(%q) should have failed", list)
+		}
+	}
+}
+
+// Every file gets a row of its own, so paths with spaces and commas read back as they were
+func TestWriteGapsCSV(t *testing.T) {
+	found := []Gap{{
+		Identifier: "20230101-001",
+		Versions:   []string{"raw", "workprint"},
+		Files:      []string{"/photos/new work/20230101-001_granite_raw.cr2", "/photos/granite, again/20230101-001_granite_workprint.tif"},
+	}}
+
+	var out bytes.Buffer
+	err := writeGapsCSV(&out, found)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, expected a header and one for each file", len(records))
+	}
+	for i, file := range found[0].Files {
+		want := []string{"20230101-001", "raw workprint", file}
+		if !slices.Equal(records[i+1], want) {
+			t.Errorf("record %d is %q, expected %q", i+1, records[i+1], want)
+		}
+	}
+}
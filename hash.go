@@ -0,0 +1,433 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	hash.go
+*/
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+/*
+	The manifest is a SHA-256 checksum of every image file in the archive, kept in the _loupe
+	folder next to the journal. It is written in the same format sha256sum uses, one checksum and
+	path per line, so "sha256sum -c _loupe/manifest.sha256" run from the archive checks it without
+	Loupe. Paths are relative to the archive and always use forward slashes, so the manifest keeps
+	working when the archive moves between drives and systems.
+*/
+
+const manifestName = "manifest.sha256"
+
+// Checksums keyed by the path of the file relative to the archive
+type Manifest map[string]string
+
+// Reads the manifest of a directory. A directory that has never been hashed has an empty manifest
+func readManifest(dir string) (Manifest, bool, error) {
+	manifest := make(Manifest)
+
+	file, err := os.Open(filepath.Join(dir, loupeDir, manifestName))
+	if os.IsNotExist(err) {
+		return manifest, false, nil
+	}
+	if err != nil {
+		return nil, false, errors.Join(errors.New("trouble opening the manifest"), err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if scanner.Text() == "" {
+			continue
+		}
+
+		// sha256sum marks files hashed in binary mode with a * in front of the path
+		sum, path, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			sum, path, ok = strings.Cut(scanner.Text(), " *")
+		}
+		if !ok || len(sum) != sha256.Size*2 {
+			return nil, false, errors.New(fmt.Sprintf("line %d of the manifest is not a checksum and path", line))
+		}
+		manifest[path] = sum
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, errors.Join(errors.New("trouble reading the manifest"), err)
+	}
+
+	return manifest, true, nil
+}
+
+// Writes the manifest sorted by path. It is written to a temporary file first and renamed over the
+// old one, so an interruption never leaves half a manifest behind
+func writeManifest(dir string, manifest Manifest) error {
+	err := os.MkdirAll(filepath.Join(dir, loupeDir), 0755)
+	if err != nil {
+		return errors.Join(errors.New("trouble while creating the manifest folder"), err)
+	}
+
+	paths := make([]string, 0, len(manifest))
+	for path := range manifest {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	var contents strings.Builder
+	for _, path := range paths {
+		contents.WriteString(manifest[path] + "  " + path + "\n")
+	}
+
+	path := filepath.Join(dir, loupeDir, manifestName)
+	err = os.WriteFile(path+".tmp", []byte(contents.String()), 0644)
+	if err != nil {
+		return errors.Join(errors.New("trouble while writing the manifest"), err)
+	}
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		return errors.Join(errors.New("trouble while writing the manifest"), err)
+	}
+	return nil
+}
+
+// The key a file has in the manifest of a directory
+func manifestPath(dir, path string) (string, error) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// True if the path is somewhere inside the directory
+func inside(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Computes the SHA-256 checksum of a file
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", errors.Join(errors.New("trouble opening \""+path+"\""), err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", errors.Join(errors.New("trouble reading \""+path+"\""), err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Checks that two files have the same contents, returning their checksum
+func sameFiles(a, b string) (string, error) {
+	sumA, err := hashFile(a)
+	if err != nil {
+		return "", err
+	}
+	sumB, err := hashFile(b)
+	if err != nil {
+		return "", err
+	}
+
+	if sumA != sumB {
+		return "", errors.New("\"" + a + "\" and \"" + b + "\" are not the same")
+	}
+	return sumA, nil
+}
+
+// Copies a file, keeping its modification time, and returns its checksum. The copy is written next
+// to its destination under a temporary name, flushed to disk and read back to check it against the
+// original before it is renamed into place, so a bad copy never shows up under a real name
+func copyFile(from, to string) (string, error) {
+	source, err := os.Open(from)
+	if err != nil {
+		return "", errors.Join(errors.New("trouble opening \""+from+"\""), err)
+	}
+	defer source.Close()
+
+	stats, err := source.Stat()
+	if err != nil {
+		return "", errors.Join(errors.New("trouble reading \""+from+"\""), err)
+	}
+
+	temp := to + ".part"
+	destination, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, stats.Mode().Perm())
+	if err != nil {
+		return "", errors.Join(errors.New("trouble creating \""+temp+"\""), err)
+	}
+
+	// Hash the original as it is read, so it only has to be read once
+	hash := sha256.New()
+	_, err = io.Copy(destination, io.TeeReader(source, hash))
+	if err == nil {
+		err = destination.Sync()
+	}
+	err = errors.Join(err, destination.Close())
+	if err != nil {
+		os.Remove(temp)
+		return "", errors.Join(errors.New("trouble copying \""+from+"\""), err)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	copied, err := hashFile(temp)
+	if err != nil || copied != sum {
+		os.Remove(temp)
+		return "", errors.Join(errors.New("the copy of \""+from+"\" doesn't match the original"), err)
+	}
+
+	err = os.Chtimes(temp, stats.ModTime(), stats.ModTime())
+	if err != nil {
+		os.Remove(temp)
+		return "", errors.Join(errors.New("trouble copying \""+from+"\""), err)
+	}
+
+	// Same as a move, never overwrite anything
+	if exists(to) {
+		os.Remove(temp)
+		return "", errors.New("refusing to overwrite \"" + to + "\"")
+	}
+	err = os.Rename(temp, to)
+	if err != nil {
+		os.Remove(temp)
+		return "", errors.Join(errors.New("trouble copying \""+from+"\""), err)
+	}
+
+	return sum, nil
+}
+
+// Adds every image file in the archive to its manifest. Files already in the manifest keep their
+// checksum unless rehash is set, so a file that has rotted since can't quietly replace a good one
+func hash(dir string, rehash bool) error {
+	fmt.Println("Loupe", loupeVersion, "-", "Hash")
+
+	// Check that the -a flag was used
+	if dir == "" {
+		return errors.New("provide an archive directory using the -a flag")
+	}
+
+	// Check that the given directory exists
+	stats, err := os.Stat(dir)
+	if os.IsNotExist(err) || !stats.IsDir() {
+		return errors.New("directory \"" + dir + "\" not found")
+	}
+
+	// Get a list of image files in the directory and its subdirectories
+	files, err := getImageFiles(dir)
+	if err != nil {
+		return errors.Join(errors.New("trouble getting image files from \""+dir+"\""), err)
+	}
+
+	manifest, _, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	// Only keep the files that are still in the archive
+	updated := make(Manifest)
+	var added, kept int
+	for _, file := range files {
+		path, err := manifestPath(dir, file)
+		if err != nil {
+			return err
+		}
+
+		if sum, ok := manifest[path]; ok && !rehash {
+			updated[path] = sum
+			kept++
+			continue
+		}
+
+		sum, err := hashFile(file)
+		if err != nil {
+			return err
+		}
+		updated[path] = sum
+		added++
+	}
+
+	err = writeManifest(dir, updated)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(added, "file(s) hashed,", kept, "already in the manifest")
+
+	var dropped int
+	for path := range manifest {
+		if _, ok := updated[path]; !ok {
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		fmt.Println(dropped, "file(s) no longer in the archive were taken out of the manifest")
+	}
+	return nil
+}
+
+// Hashes every image file in the archive again and compares it to the manifest. Returns how many
+// files changed or went missing, so scripts can fail on them
+func verify(dir string) (int, error) {
+	fmt.Println("Loupe", loupeVersion, "-", "Verify")
+
+	// Check that the -a flag was used
+	if dir == "" {
+		return 0, errors.New("provide an archive directory using the -a flag")
+	}
+
+	// Check that the given directory exists
+	stats, err := os.Stat(dir)
+	if os.IsNotExist(err) || !stats.IsDir() {
+		return 0, errors.New("directory \"" + dir + "\" not found")
+	}
+
+	manifest, found, err := readManifest(dir)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, errors.New("\"" + dir + "\" has no manifest, run \"loupe hash -a\" first")
+	}
+
+	// Get a list of image files in the directory and its subdirectories
+	files, err := getImageFiles(dir)
+	if err != nil {
+		return 0, errors.Join(errors.New("trouble getting image files from \""+dir+"\""), err)
+	}
+
+	var changed, newFiles, missing []string
+	seen := make(map[string]bool)
+	for _, file := range files {
+		path, err := manifestPath(dir, file)
+		if err != nil {
+			return 0, err
+		}
+		seen[path] = true
+
+		expected, ok := manifest[path]
+		if !ok {
+			newFiles = append(newFiles, path)
+			continue
+		}
+
+		sum, err := hashFile(file)
+		if err != nil {
+			return 0, err
+		}
+		if sum != expected {
+			changed = append(changed, path)
+		}
+	}
+
+	for path := range manifest {
+		if !seen[path] {
+			missing = append(missing, path)
+		}
+	}
+	slices.Sort(missing)
+
+	if len(changed) > 0 {
+		fmt.Println("Changed since they were hashed:")
+		for _, path := range changed {
+			fmt.Println("    " + path)
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Println("Missing:")
+		for _, path := range missing {
+			fmt.Println("    " + path)
+		}
+	}
+	if len(newFiles) > 0 {
+		fmt.Println("New, run hash to add them to the manifest:")
+		for _, path := range newFiles {
+			fmt.Println("    " + path)
+		}
+	}
+
+	fmt.Println(len(files)-len(newFiles)-len(changed), "file(s) verified,", len(changed), "changed,", len(missing), "missing,", len(newFiles), "new")
+	return len(changed) + len(missing), nil
+}
+
+// Moves the manifest entries of every file the actions moved or renamed, so the manifest follows
+// the archive as it is sorted and refactored. Files copied into the directory are added with the
+// checksums worked out while copying them, and deleted files are taken out. A directory without a
+// manifest is left alone
+func updateManifest(dir string, actions []Action, sums map[string]string) error {
+	manifest, found, err := readManifest(dir)
+	if err != nil || !found {
+		return err
+	}
+
+	updated := false
+	for _, a := range actions {
+		switch a.Op {
+		case actionMove, actionRename:
+			from, err := manifestPath(dir, a.From)
+			if err != nil {
+				return err
+			}
+			sum, ok := manifest[from]
+			if !ok {
+				continue
+			}
+
+			delete(manifest, from)
+			if inside(dir, a.To) {
+				to, err := manifestPath(dir, a.To)
+				if err != nil {
+					return err
+				}
+				manifest[to] = sum
+			}
+			updated = true
+
+		case actionCopy:
+			if !inside(dir, a.To) {
+				continue
+			}
+
+			// A copy made before a run was interrupted has to be read again
+			sum, ok := sums[a.To]
+			if !ok {
+				sum, err = hashFile(a.To)
+				if err != nil {
+					continue
+				}
+			}
+
+			to, err := manifestPath(dir, a.To)
+			if err != nil {
+				return err
+			}
+			manifest[to] = sum
+			updated = true
+
+		case actionDelete:
+			path, err := manifestPath(dir, a.Path)
+			if err != nil {
+				return err
+			}
+			if _, ok := manifest[path]; ok {
+				delete(manifest, path)
+				updated = true
+			}
+		}
+	}
+
+	if !updated {
+		return nil
+	}
+	return writeManifest(dir, manifest)
+}
@@ -0,0 +1,326 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	help.go
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const readmeLink = "https://github.com/karlramberg/loupe#readme"
+
+// Everything help knows about a command beyond its flags
+type commandDoc struct {
+	summary     string
+	usage       string
+	description string
+	examples    []string
+	naming      bool // Whether the naming rules matter to the command
+}
+
+// Looked up by the name of each command's flag set
+var commandDocs = map[string]commandDoc{
+	"name": {
+		summary: "Name new photographs from scratch",
+		usage:   "loupe name -w <working directory>",
+		description: "Gives new photographs shiny new filenames. Asks for a selection of files, then for every\n" +
+			"part of a filename, ignoring any name the files had before. Only use it in a working\n" +
+			"directory, never in your archive. Enter \"auto\" as the date to read it from each file's\n" +
+			"metadata, falling back to its modification time for raw files only. Any prompt can be\n" +
+			"answered with its flag instead, and -batch reads the attributes of each file from a CSV or\n" +
+			"JSON file. Numbering continues after the highest number used on each date and roll in the\n" +
+			"working directory, and in the archive given with -a. In a terminal, files are picked from a\n" +
+			"list with the arrow keys and space, and / filters it. TERM=dumb asks for a selection\n" +
+			"expression instead.",
+		examples: []string{
+			"loupe name -w ~/scans",
+			"loupe name -w ~/scans -dry-run",
+			"loupe name -w ~/tethered -date auto -group chalk -version raw -yes",
+			"loupe name -w ~/scans -batch scans.csv",
+			"loupe name -w ~/scans -a ~/photographs",
+		},
+		naming: true,
+	},
+	"modify": {
+		summary: "Change attributes of selected photographs",
+		usage:   "loupe modify -w <working directory>",
+		description: "Changes the date, roll letter, number, class, group, version or subversion of selected\n" +
+			"photographs that already have a valid name. Press enter to keep an attribute as it is.\n" +
+			"Photographs are picked the same way as with name.",
+		examples: []string{
+			"loupe modify -w ~/scans",
+		},
+		naming: true,
+	},
+	"ingest": {
+		summary: "Copy named photographs into the archive",
+		usage:   "loupe ingest -w <working directory> -a <archive directory>",
+		description: "Copies every photograph in the working directory straight into its folder in the archive,\n" +
+			"checking each copy against the original. Refuses to start if any file isn't validly named\n" +
+			"or has an identifier already in the archive, so run name on the working directory first.\n" +
+			"The originals are deleted once every copy is checked, unless -keep is given.",
+		examples: []string{
+			"loupe ingest -w ~/scans -a ~/photographs -dry-run",
+			"loupe name -w ~/tethered -a ~/photographs -date auto -group chalk -version raw -yes",
+			"loupe ingest -w ~/tethered -a ~/photographs -keep",
+		},
+		naming: true,
+	},
+	"sort": {
+		summary: "Sort an archive into folders",
+		usage:   "loupe sort -a <archive directory>",
+		description: "Moves validly named photographs into class/group/version/subversion folders and moves\n" +
+			"invalidly named ones to the base of the archive to be fixed. Folders starting with an\n" +
+			"underscore are left alone. A file whose destination is taken is compared to the file\n" +
+			"there, and -remove-dupes deletes it if the two are identical.",
+		examples: []string{
+			"loupe sort -a ~/photographs",
+			"loupe sort -a ~/photographs -dry-run -json plan.json",
+			"loupe sort -a ~/photographs -remove-dupes",
+		},
+		naming: true,
+	},
+	"refactor": {
+		summary: "Rename a class, group, version or subversion",
+		usage:   "loupe refactor -a <archive directory> -t <type> -o <old name> -n <new name>",
+		description: "Renames a class, group, version or subversion in every filename that has it, then sorts\n" +
+			"the archive so the files land in their new folders. -pick lists every file that would be\n" +
+			"renamed to choose from, and the files left out keep their names.",
+		examples: []string{
+			"loupe refactor -a ~/photographs -t group -o granit -n granite",
+			"loupe refactor -a ~/photographs -t version -o negative -n neg -dry-run",
+			"loupe refactor -a ~/photographs -t group -o granite -n basalt -pick",
+		},
+	},
+	"print": {
+		summary:     "Print a timeline of photographs",
+		usage:       "loupe print -a <archive directory>",
+		description: "Prints a timeline of every validly named photograph, ordered by identifier.",
+		examples: []string{
+			"loupe print -a ~/photographs",
+			"loupe print -a ~/photographs -from 20230101 -to 20231231 -group granite -format csv",
+		},
+	},
+	"find": {
+		summary: "Find files by their names",
+		usage:   "loupe find -a <archive directory> [flags] <field=value> ...",
+		description: "Lists every validly named file matching all of the terms after the flags. Fields are date,\n" +
+			"letter, number, class, group, version, subversion and ext. Use != to leave files out, commas\n" +
+			"for any of several values, from..to for a range of dates or numbers, a year or month for a\n" +
+			"whole year or month, and * or ? in words. Prints one path per line unless -format is given.",
+		examples: []string{
+			"loupe find -a ~/photographs group=granite version=print date=2023",
+			"loupe find -a ~/photographs -format table letter=B date=20230601..20230630",
+			"loupe find -a ~/photographs version=master,print number=..020 ext!=jpg",
+		},
+	},
+	"gaps": {
+		summary: "List photographs missing a version",
+		usage:   "loupe gaps -a <archive directory> -missing <version>",
+		description: "Lists every identifier that has a file of a -have version but no file of any -missing\n" +
+			"version, along with the versions it does have. Without -have every identifier is listed.\n" +
+			"Both take several versions separated by commas, and a version-subversion or\n" +
+			"version/subversion to be exact. /subversion matches that subversion of any version.",
+		examples: []string{
+			"loupe gaps -a ~/photographs -have raw -missing master",
+			"loupe gaps -a ~/photographs -have workprint -missing final,print-8x10 -format csv",
+			"loupe gaps -a ~/photographs -have master -missing /crop",
+		},
+	},
+	"rolls": {
+		summary: "List rolls of film and their details",
+		usage:   "loupe rolls -a <archive directory>",
+		description: "Lists every roll of film in the archive and in the registry at _loupe/rolls.txt, with how\n" +
+			"many frames each has, which frames are missing and the film stock, camera, ISO, developer\n" +
+			"and notes. -add puts a roll in the registry or changes the details given for it.",
+		examples: []string{
+			"loupe rolls -a ~/photographs",
+			"loupe rolls -a ~/photographs -add 20230615-B -stock \"Kodak Portra 400\" -camera \"Nikon F3\" -iso 400",
+		},
+	},
+	"locate": {
+		summary: "Record where negatives and prints are kept",
+		usage:   "loupe locate -a <archive directory> [flags] [identifier, roll or date] ...",
+		description: "Keeps a catalogue at _loupe/locations.txt of where the physical objects of each\n" +
+			"identifier are, one location for every kind of object. -add records one, replacing where\n" +
+			"it was. Otherwise lists the locations of the identifiers, rolls or dates after the flags,\n" +
+			"or all of them. -missing lists identifiers with files but no location, of -kind if given.",
+		examples: []string{
+			"loupe locate -a ~/photographs -add 20230615-B07 -kind negative -at \"Binder 3, sleeve 12\"",
+			"loupe locate -a ~/photographs 20230615-B",
+			"loupe locate -a ~/photographs -missing -kind negative",
+		},
+	},
+	"serve": {
+		summary: "Browse an archive in a web browser",
+		usage:   "loupe serve -a <archive directory>",
+		description: "Starts a local web server for browsing the archive the way it is organized: its folders,\n" +
+			"a timeline of identifiers, and thumbnails of JPEGs, PNGs and the previews inside raw files.\n" +
+			"The same is there as JSON under /api/ for scripts. Changes nothing. Serves on localhost\n" +
+			"only, unless -addr says otherwise.",
+		examples: []string{
+			"loupe serve -a ~/photographs",
+			"loupe serve -a ~/photographs -addr localhost:9000",
+		},
+	},
+	"check": {
+		summary: "Report problems in an archive",
+		usage:   "loupe check -a <archive directory>",
+		description: "Reports filenames found in more than one folder, identifiers used in more than one group,\n" +
+			"files sort would move, invalid names, empty folders, and rolls and locations with no files.\n" +
+			"Changes nothing. Exits with 1 if there were any problems and 2 if the archive couldn't be\n" +
+			"checked.",
+		examples: []string{
+			"loupe check -a ~/photographs",
+			"loupe check -a ~/photographs || echo \"the archive needs attention\"",
+		},
+		naming: true,
+	},
+	"dupes": {
+		summary: "Find duplicate files",
+		usage:   "loupe dupes -a <archive directory>",
+		description: "Compares the contents of every file in the archive and lists exact duplicates, along with\n" +
+			"names shared by files with different contents. Changes nothing unless -remove is given,\n" +
+			"which deletes every exact duplicate but one, keeping the copy best placed in the archive.\n" +
+			"Only duplicates sharing a name or identifier with the kept copy are deleted, since files\n" +
+			"that match under different names may be different photographs. -across-names deletes\n" +
+			"those too. The sidecars of a deleted duplicate go to the copy that is kept.",
+		examples: []string{
+			"loupe dupes -a ~/photographs",
+			"loupe dupes -a ~/photographs -remove -dry-run",
+			"loupe dupes -a ~/photographs -remove -across-names",
+		},
+	},
+	"hash": {
+		summary: "Record a checksum of every file",
+		usage:   "loupe hash -a <archive directory>",
+		description: "Adds a SHA-256 checksum of every new image file to the manifest at _loupe/manifest.sha256\n" +
+			"and takes out files that are gone. Checksums already in the manifest are kept unless\n" +
+			"-rehash is given. Sort, refactor and undo keep the manifest up to date as they move files.",
+		examples: []string{
+			"loupe hash -a ~/photographs",
+			"loupe hash -a ~/photographs -rehash",
+		},
+	},
+	"verify": {
+		summary: "Check files against their checksums",
+		usage:   "loupe verify -a <archive directory>",
+		description: "Hashes every image file again and reports files that changed or went missing since they\n" +
+			"were hashed, and new files that haven't been hashed yet. Changes nothing. Exits with 1 if\n" +
+			"any file changed or went missing and 2 if the archive couldn't be verified.",
+		examples: []string{
+			"loupe verify -a ~/photographs",
+		},
+	},
+	"index": {
+		summary: "Keep a record of the archive for quick reading",
+		usage:   "loupe index -a <archive directory>",
+		description: "Builds an index of every image file at _loupe/index.json, or brings it up to date. Once\n" +
+			"an archive has an index, print, check and the numbering in name and ingest read it instead\n" +
+			"of the whole archive, only reading the folders that changed since. -rebuild starts over.",
+		examples: []string{
+			"loupe index -a ~/photographs",
+			"loupe index -a ~/photographs -rebuild",
+		},
+	},
+	"undo": {
+		summary:     "Undo the last change to a directory",
+		usage:       "loupe undo -a <archive directory>",
+		description: "Reverses the last change recorded in the directory's journal.",
+		examples: []string{
+			"loupe undo -a ~/photographs",
+			"loupe undo -a ~/photographs -dry-run",
+		},
+	},
+}
+
+// The rules photo.Parse enforces on every filename
+const namingRules = `Filenames are formatted date-number_group_version.extension
+  date        YYYYMMDD, and has to be a real day
+  number      a whole number padded to 3 digits (007), or a roll letter
+              and a frame padded to 2 digits (B07). Past 999 or frame 99,
+              the nines come first (9991024, B99104). After roll Z is ZA
+  group       lowercase letters and digits, or class-group to give it a class
+  version     lowercase letters and digits, or version-subversion
+Only one underscore goes between each part and only one hyphen inside each part
+e.g. 20241201-007_granite_master.tif or 20270630-B28_trip-berlin2023_print-8x10.tif
+`
+
+// Makes every command print its own help for -h or a bad flag
+func setUsage(cmds []*flag.FlagSet) {
+	for _, cmd := range cmds {
+		cmd := cmd
+		cmd.SetOutput(os.Stdout)
+		cmd.Usage = func() {
+			printCommandHelp(cmd)
+		}
+	}
+}
+
+// Prints the usage, description, flags and examples of one command
+func printCommandHelp(cmd *flag.FlagSet) {
+	doc := commandDocs[cmd.Name()]
+
+	fmt.Println("Usage:", doc.usage)
+	fmt.Println()
+	fmt.Println(doc.description)
+	fmt.Println()
+	fmt.Println("Flags:")
+	cmd.PrintDefaults()
+
+	if len(doc.examples) > 0 {
+		fmt.Println()
+		fmt.Println("Examples:")
+		for _, example := range doc.examples {
+			fmt.Println("  " + example)
+		}
+	}
+
+	if doc.naming {
+		fmt.Println()
+		fmt.Print(namingRules)
+	}
+}
+
+func help(args []string, cmds []*flag.FlagSet) error {
+	fmt.Println("Loupe", loupeVersion, "-", "Help")
+	fmt.Println()
+
+	// Help for a single command
+	if len(args) > 0 {
+		for _, cmd := range cmds {
+			if cmd.Name() == args[0] {
+				printCommandHelp(cmd)
+				return nil
+			}
+		}
+		return errors.New("command \"" + args[0] + "\" not found")
+	}
+
+	// Otherwise an abridged version of the README
+	fmt.Println("Loupe is a set of commands to organize photographs. Filenames hold their own metadata,")
+	fmt.Println("and photographs are sorted into folders by class, group, version and subversion.")
+	fmt.Println()
+	fmt.Print(namingRules)
+	fmt.Println()
+	fmt.Println("Commands:")
+
+	for _, cmd := range cmds {
+		fmt.Printf("  %-10s %s\n", cmd.Name(), commandDocs[cmd.Name()].summary)
+	}
+	fmt.Printf("  %-10s %s\n", "help", "Print this, or the flags and examples of a command")
+	fmt.Println()
+	fmt.Println("Every command but help needs a -w or -a flag, so you always have to think about whether")
+	fmt.Println("you are working in a working directory or in your archive.")
+	fmt.Println()
+	fmt.Println("Run \"loupe help <command>\" or \"loupe <command> -h\" for a command's flags and examples.")
+	fmt.Println("The full README is at", readmeLink)
+
+	return nil
+}
@@ -0,0 +1,322 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	index.go
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/karlramberg/loupe/photo"
+)
+
+/*
+	The index is a record of every image file in the archive, kept in the _loupe folder so
+	commands that only read the archive don't have to walk and parse all of it each time. It is
+	optional, nothing uses it until "loupe index" has built it once. Adding, removing or renaming
+	a file changes the modification time of the folder it is in, so the index keeps the time of
+	every folder and only reads the folders whose time has changed since. Some file systems only
+	keep times to the second or two, so a folder changed right around when the index was written
+	is read again the next time to be safe. Editing a file in place doesn't change its folder, so
+	the sizes and times in the index can be out of date. Use hash and verify to check contents.
+*/
+
+const indexName = "index.json"
+
+// Bumped whenever the layout of the index changes, so an old index is rebuilt instead of misread
+const indexVersion = 3
+
+// How close to the time the index was written a folder has to change to be read again regardless
+const indexSlack = 2 * time.Second
+
+type Index struct {
+	Version int                    `json:"version"`
+	Written time.Time              `json:"written"`
+	Dirs    map[string]*IndexedDir `json:"dirs"` // Keyed by the path relative to the archive
+}
+
+// A folder in the index, with the subfolders that are looked at and the image files it holds
+type IndexedDir struct {
+	ModTime time.Time     `json:"modTime"`
+	Subdirs []string      `json:"subdirs,omitempty"`
+	Files   []IndexedFile `json:"files,omitempty"`
+}
+
+type IndexedFile struct {
+	Name       string            `json:"name"`
+	Size       int64             `json:"size"`
+	ModTime    time.Time         `json:"modTime"`
+	Photograph *photo.Photograph `json:"photograph,omitempty"` // Missing if the name isn't valid
+}
+
+// Reads the index of an archive, returning false if it has never been built or was built by a
+// version of Loupe that laid it out differently
+func readIndex(dir string) (*Index, bool, error) {
+	data, err := os.ReadFile(filepath.Join(dir, loupeDir, indexName))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, errors.Join(errors.New("trouble opening the index"), err)
+	}
+
+	var idx Index
+	if json.Unmarshal(data, &idx) != nil || idx.Version != indexVersion || idx.Dirs == nil {
+		return nil, false, nil
+	}
+	return &idx, true, nil
+}
+
+// Writes the index to a temporary file first, so an interrupted write never leaves half an index
+func writeIndex(dir string, idx *Index) error {
+	err := os.MkdirAll(filepath.Join(dir, loupeDir), 0755)
+	if err != nil {
+		return errors.Join(errors.New("trouble creating \""+filepath.Join(dir, loupeDir)+"\""), err)
+	}
+
+	idx.Version = indexVersion
+	idx.Written = time.Now()
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, loupeDir, indexName)
+	err = os.WriteFile(path+".tmp", data, 0644)
+	if err != nil {
+		return errors.Join(errors.New("trouble writing the index"), err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Brings the index up to date with the archive, reading only the folders that changed. Returns how
+// many folders had to be read
+func (idx *Index) refresh(dir string) (int, error) {
+	seen := make(map[string]bool)
+	read := 0
+
+	var visit func(rel string) error
+	visit = func(rel string) error {
+		seen[rel] = true
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+
+		stats, err := os.Stat(path)
+		if err != nil {
+			return errors.Join(errors.New("trouble reading \""+path+"\""), err)
+		}
+
+		indexed, ok := idx.Dirs[rel]
+		fresh := ok && indexed.ModTime.Equal(stats.ModTime()) &&
+			stats.ModTime().Before(idx.Written.Add(-indexSlack))
+		if !fresh {
+			indexed, err = indexDir(path, stats.ModTime())
+			if err != nil {
+				return err
+			}
+			idx.Dirs[rel] = indexed
+			read++
+		}
+
+		for _, subdir := range indexed.Subdirs {
+			err := visit(relJoin(rel, subdir))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err := visit(".")
+	if err != nil {
+		return 0, err
+	}
+
+	// Forget the folders that are gone
+	for rel := range idx.Dirs {
+		if !seen[rel] {
+			delete(idx.Dirs, rel)
+			read++
+		}
+	}
+	return read, nil
+}
+
+// Reads one folder for the index
+func indexDir(path string, modTime time.Time) (*IndexedDir, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, errors.Join(errors.New("trouble reading files from \""+path+"\""), err)
+	}
+
+	indexed := &IndexedDir{ModTime: modTime}
+	for _, entry := range entries {
+		// Ignore any folder that starts with an underscore, just like getImageFiles
+		if entry.IsDir() {
+			if entry.Name()[0] != '_' {
+				indexed.Subdirs = append(indexed.Subdirs, entry.Name())
+			}
+			continue
+		}
+
+		if !isImage(entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, errors.Join(errors.New("trouble reading \""+filepath.Join(path, entry.Name())+"\""), err)
+		}
+
+		file := IndexedFile{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime()}
+		if photograph, err := photo.Parse(entry.Name()); err == nil {
+			file.Photograph = &photograph
+		}
+		indexed.Files = append(indexed.Files, file)
+	}
+	return indexed, nil
+}
+
+// Joins a path relative to the archive, keeping the forward slashes the index uses
+func relJoin(rel, name string) string {
+	if rel == "." {
+		return name
+	}
+	return rel + "/" + name
+}
+
+// Lists every image file in the index, in the same order getImageFiles would
+func (idx *Index) files(dir string) (files []ScannedFile) {
+	for rel, indexed := range idx.Dirs {
+		for _, file := range indexed.Files {
+			scanned := ScannedFile{Path: filepath.Join(dir, filepath.FromSlash(rel), file.Name)}
+			if file.Photograph != nil {
+				scanned.Photograph = *file.Photograph
+			} else {
+				// Only the invalid names are parsed again, to find out what is wrong with them
+				scanned.Photograph, scanned.Err = photo.Parse(file.Name)
+			}
+			files = append(files, scanned)
+		}
+	}
+
+	slices.SortFunc(files, func(a, b ScannedFile) int {
+		return comparePaths(a.Path, b.Path)
+	})
+	return files
+}
+
+// Gets every image file in an archive along with its parsed photograph. The index is used if the
+// archive has one, and brought up to date first. Otherwise the archive is walked like always.
+// Commands that only read the archive leave save unset, so they bring the index up to date in
+// memory and never write to the archive themselves
+func getArchiveFiles(dir string, save bool) ([]ScannedFile, error) {
+	idx, found, err := readIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		paths, err := getImageFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		files := make([]ScannedFile, len(paths))
+		for i, path := range paths {
+			photograph, err := photo.Parse(filepath.Base(path))
+			files[i] = ScannedFile{Path: path, Photograph: photograph, Err: err}
+		}
+		return files, nil
+	}
+
+	read, err := idx.refresh(dir)
+	if err != nil {
+		return nil, err
+	}
+	if read > 0 && save {
+		err = writeIndex(dir, idx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return idx.files(dir), nil
+}
+
+// Brings the index of an archive up to date after a command changed files in it, if it has one
+func updateIndex(dir string) error {
+	idx, found, err := readIndex(dir)
+	if err != nil || !found {
+		return err
+	}
+
+	read, err := idx.refresh(dir)
+	if err != nil || read == 0 {
+		return err
+	}
+	return writeIndex(dir, idx)
+}
+
+// Just the paths of every image file in an archive, using the index if there is one. Only used by
+// commands about to change the archive, so the index is saved along the way
+func getArchivePaths(dir string) ([]string, error) {
+	files, err := getArchiveFiles(dir, true)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, len(files))
+	for i, file := range files {
+		paths[i] = file.Path
+	}
+	return paths, nil
+}
+
+// Builds the index of an archive, or brings it up to date. With rebuild set, the old index is
+// thrown away and every folder is read again
+func index(dir string, rebuild bool) error {
+	fmt.Println("Loupe", loupeVersion, "-", "Index")
+
+	// Check that the -a flag was used
+	if dir == "" {
+		return errors.New("provide an archive directory using the -a flag")
+	}
+
+	// Check that the given directory exists
+	stats, err := os.Stat(dir)
+	if os.IsNotExist(err) || !stats.IsDir() {
+		return errors.New("directory \"" + dir + "\" not found")
+	}
+
+	idx, found, err := readIndex(dir)
+	if err != nil {
+		return err
+	}
+	if !found || rebuild {
+		idx = &Index{Dirs: make(map[string]*IndexedDir)}
+	}
+
+	read, err := idx.refresh(dir)
+	if err != nil {
+		return err
+	}
+
+	err = writeIndex(dir, idx)
+	if err != nil {
+		return err
+	}
+
+	files := 0
+	for _, indexed := range idx.Dirs {
+		files += len(indexed.Files)
+	}
+	fmt.Println(files, "file(s) in", len(idx.Dirs), "folder(s) indexed,", read, "folder(s) read")
+	return nil
+}
@@ -0,0 +1,61 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	index_test.go
+*/
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Reading an archive with an out of date index sees the new files without writing the index again
+func TestGetArchiveFilesReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "granite", "masters", "20230101-001_granite_master.tif"))
+	err := index(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexFile := filepath.Join(dir, loupeDir, indexName)
+	before, err := os.ReadFile(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "chalk", "masters", "20230101-002_chalk_master.tif"))
+	files, err := getArchiveFiles(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("found %d file(s), expected 2", len(files))
+	}
+
+	after, err := os.ReadFile(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("reading the archive wrote the index")
+	}
+
+	// A command changing files saves the index once it is done
+	p := newPlan("sort", dir)
+	p.move(filepath.Join(dir, "chalk", "masters", "20230101-002_chalk_master.tif"), filepath.Join(dir, "20230101-002_chalk_master.tif"))
+	err = p.execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, found, err := readIndex(dir)
+	if err != nil || !found {
+		t.Fatalf("no index after running a plan: %v", err)
+	}
+	if len(idx.Dirs["."].Files) != 1 || idx.Dirs["."].Files[0].Name != "20230101-002_chalk_master.tif" {
+		t.Errorf("the index wasn't brought up to date after running a plan: %+v", idx.Dirs["."])
+	}
+}
@@ -0,0 +1,185 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	ingest.go
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/karlramberg/loupe/photo"
+)
+
+// Copies named photographs from a working directory straight into their folders in the archive.
+// Nothing is ingested unless every file is validly named and none of their identifiers are in the
+// archive yet. Sources are only deleted once every copy has been checked, unless keep is set
+func ingest(workDir, archiveDir string, keep, dryRun bool, jsonPath string) error {
+	fmt.Println("Loupe", loupeVersion, "-", "Ingest")
+
+	// Check that the -w and -a flags were used
+	if workDir == "" {
+		return errors.New("provide a working directory using the -w flag")
+	}
+	if archiveDir == "" {
+		return errors.New("provide an archive directory using the -a flag")
+	}
+
+	// Check that the given directories exist
+	stats, err := os.Stat(workDir)
+	if os.IsNotExist(err) || !stats.IsDir() {
+		return errors.New("directory \"" + workDir + "\" not found")
+	}
+	stats, err = os.Stat(archiveDir)
+	if os.IsNotExist(err) || !stats.IsDir() {
+		return errors.New("directory \"" + archiveDir + "\" not found")
+	}
+
+	// Ingesting a directory into itself, or into one it holds, would copy files onto themselves
+	workAbs, err := filepath.Abs(workDir)
+	if err != nil {
+		return err
+	}
+	archiveAbs, err := filepath.Abs(archiveDir)
+	if err != nil {
+		return err
+	}
+	if inside(workAbs, archiveAbs) || inside(archiveAbs, workAbs) {
+		return errors.New("the working directory and archive can't be inside one another")
+	}
+
+	// Setup a scanner to standard input for the user to give values
+	scanner := bufio.NewScanner(os.Stdin)
+
+	// Offer to finish or roll back an ingest that was cut short before starting another
+	if !dryRun {
+		okay, err := recoverInterrupted(archiveDir, scanner)
+		if err != nil || !okay {
+			return err
+		}
+	}
+
+	// Get a list of image files in the working directory and its subdirectories
+	files, err := getImageFiles(workDir)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("no image files found in \"" + workDir + "\"")
+	}
+
+	// Every file has to be named before it goes in the archive
+	var photos []photo.Photograph
+	var invalidFiles []string
+	for _, file := range files {
+		photograph, err := photo.Parse(filepath.Base(file))
+		if err != nil {
+			invalidFiles = append(invalidFiles, file)
+			continue
+		}
+		photos = append(photos, photograph)
+	}
+	if len(invalidFiles) > 0 {
+		fmt.Println("Fix list:")
+		fmt.Print(getFixList(invalidFiles))
+		return errors.New(strconv.Itoa(len(invalidFiles)) + " file(s) need to be named before anything can be ingested")
+	}
+
+	// An identifier already in the archive belongs to another photograph
+	archiveFiles, err := getArchivePaths(archiveDir)
+	if err != nil {
+		return err
+	}
+	used, err := findUsedNumbers(archiveFiles, nil)
+	if err != nil {
+		return err
+	}
+
+	var collisions []string
+	for i, photograph := range photos {
+		if used.identifiers[photograph.Identifier()] {
+			collisions = append(collisions, files[i])
+		}
+	}
+	if len(collisions) > 0 {
+		fmt.Println("Identifiers already in the archive:")
+		for _, file := range collisions {
+			fmt.Println("    " + file)
+		}
+		return errors.New(strconv.Itoa(len(collisions)) + " file(s) would collide with photographs in the archive, rename them with modify or name -a")
+	}
+
+	// Plan copying every file straight into its folder in the archive
+	plan := newPlan("ingest", archiveDir)
+	for i, photograph := range photos {
+		newdir := filepath.Join(archiveDir, photograph.Directory())
+		newpath := filepath.Join(newdir, photograph.Filename())
+		if plan.taken(newpath) {
+			return errors.New("\"" + files[i] + "\" can't be ingested, something is already at \"" + newpath + "\"")
+		}
+
+		plan.mkdir(newdir)
+		plan.copy(files[i], newpath)
+	}
+
+	// Deleting the sources comes after every copy, so nothing is deleted if any copy fails
+	if !keep {
+		for i, photograph := range photos {
+			plan.delete(files[i], filepath.Join(archiveDir, photograph.Directory(), photograph.Filename()))
+		}
+	}
+
+	// Sidecars are copied and deleted along with their photographs
+	err = plan.followSidecars()
+	if err != nil {
+		return err
+	}
+
+	if !keep {
+		// Clean up the folders the files came from, but never the working directory itself
+		entries, err := os.ReadDir(workDir)
+		if err != nil {
+			return errors.Join(errors.New("trouble while reading \""+workDir+"\""), err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && entry.Name()[0] != '_' {
+				_, err := plan.cleanEmptyDirs(filepath.Join(workDir, entry.Name()))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// A dry run only shows the plan, there is nothing to confirm
+	if dryRun {
+		return plan.run(dryRun, jsonPath)
+	}
+
+	// Ask the user for a final confirmation of the changes
+	fmt.Print(plan.table())
+	okay, err := promptConfimation(scanner, "Ingest these photographs?")
+	if err != nil {
+		return err
+	}
+
+	if !okay {
+		fmt.Println("Aborting!")
+		return nil
+	}
+
+	fmt.Println("Okay!")
+	err = plan.run(dryRun, jsonPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(len(photos), "file(s) ingested")
+	return nil
+}
@@ -0,0 +1,86 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	ingest_test.go
+*/
+
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Answers the prompts of a command that reads standard input itself with the given text
+func answerStdin(t *testing.T, answers string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stdin")
+	err := os.WriteFile(path, []byte(answers), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdin := os.Stdin
+	os.Stdin = file
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		file.Close()
+	})
+}
+
+// A copy that fails partway through the ingest stops it before any original is deleted
+func TestIngestFailedCopy(t *testing.T) {
+	work := t.TempDir()
+	archive := t.TempDir()
+	writeTestFile(t, filepath.Join(work, "20230101-001_granite_master.tif"))
+	writeTestFile(t, filepath.Join(work, "20230101-002_granite_master.tif"))
+	before := archiveContents(t, work)
+
+	// A folder where the copy of the second file would be written first makes copying it fail
+	err := os.MkdirAll(filepath.Join(archive, "granite", "masters", "20230101-002_granite_master.tif.part"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answerStdin(t, "y\n")
+	err = ingest(work, archive, false, false, "")
+	if err == nil {
+		t.Fatal("expected the ingest to fail copying the second file")
+	}
+
+	if got := archiveContents(t, work); !maps.Equal(got, before) {
+		t.Errorf("the failed ingest left %v in the working directory, expected %v", got, before)
+	}
+	if exists(filepath.Join(archive, "granite", "masters", "20230101-002_granite_master.tif")) {
+		t.Error("the file that failed to copy is in the archive")
+	}
+}
+
+// An identifier already in the archive stops the ingest before anything is copied or deleted
+func TestIngestCollision(t *testing.T) {
+	work := t.TempDir()
+	archive := t.TempDir()
+	writeTestFile(t, filepath.Join(work, "20230101-001_granite_print.tif"))
+	writeTestFile(t, filepath.Join(work, "20230101-002_granite_master.tif"))
+	writeTestFile(t, filepath.Join(archive, "granite", "masters", "20230101-001_granite_master.tif"))
+	workBefore := archiveContents(t, work)
+	archiveBefore := archiveContents(t, archive)
+
+	err := ingest(work, archive, false, false, "")
+	if err == nil {
+		t.Fatal("expected the ingest to refuse the identifier already in the archive")
+	}
+
+	if got := archiveContents(t, work); !maps.Equal(got, workBefore) {
+		t.Errorf("the refused ingest left %v in the working directory, expected %v", got, workBefore)
+	}
+	if got := archiveContents(t, archive); !maps.Equal(got, archiveBefore) {
+		t.Errorf("the refused ingest left %v in the archive, expected %v", got, archiveBefore)
+	}
+}
@@ -0,0 +1,221 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	journal.go
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Loupe keeps its own files in an underscore folder, so getImageFiles and sort never touch them
+const loupeDir = "_loupe"
+
+const journalName = "journal.jsonl"
+
+// The journal works in two phases so a command can be picked back up if it dies halfway, which
+// happens when an external drive is unplugged. Before anything is touched, every action in the
+// plan is written down as planned. Each action is written down again as done once it has happened,
+// and a final committed record closes the run. A run with no committed record was interrupted,
+// and the next sort will offer to resume it or roll it back. The manifest is brought up to date
+// with the actions that happened even when a run fails partway, and a manifest record notes
+// that it was, so a run that died before that can have its manifest caught up when it is
+// resumed or rolled back
+const (
+	statePlanned   = "planned"
+	stateDone      = "done"
+	stateManifest  = "manifest"
+	stateCommitted = "committed"
+)
+
+// One line of the journal, tagged with the run it belongs to and the step of the plan it is for.
+// Paths are relative to the directory the command was run on
+type JournalRecord struct {
+	Run     string `json:"run"`
+	Command string `json:"command"`
+	Undoes  string `json:"undoes,omitempty"`
+	Step    int    `json:"step"`
+	State   string `json:"state"`
+	Action
+}
+
+// An append-only log of every change made to a directory. It lives in the _loupe folder inside
+// the directory itself, so the history travels with the archive
+type Journal struct {
+	dir     string
+	file    *os.File
+	run     string
+	command string
+	undoes  string
+}
+
+// Opens the journal for a directory, creating it if needed. A new run is started unless the id
+// of an interrupted run is given to carry on with
+func openJournal(dir, command, undoes, run string) (*Journal, error) {
+	err := os.MkdirAll(filepath.Join(dir, loupeDir), 0755)
+	if err != nil {
+		return nil, errors.Join(errors.New("trouble while creating the journal folder"), err)
+	}
+
+	path := filepath.Join(dir, loupeDir, journalName)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Join(errors.New("trouble while opening the journal \""+path+"\""), err)
+	}
+
+	if run == "" {
+		run = time.Now().Format(time.RFC3339Nano)
+	}
+
+	return &Journal{
+		dir:     dir,
+		file:    file,
+		run:     run,
+		command: command,
+		undoes:  undoes,
+	}, nil
+}
+
+// Appends an action to the journal. Each record is synced right away so the journal is accurate
+// even if the program dies halfway through a command
+func (j *Journal) record(step int, state string, a Action) error {
+	a.From, a.To, a.Path = j.relative(a.From), j.relative(a.To), j.relative(a.Path)
+
+	data, err := json.Marshal(JournalRecord{
+		Run:     j.run,
+		Command: j.command,
+		Undoes:  j.undoes,
+		Step:    step,
+		State:   state,
+		Action:  a,
+	})
+	if err != nil {
+		return errors.Join(errors.New("trouble while encoding a journal record"), err)
+	}
+
+	_, err = j.file.Write(append(data, '\n'))
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		return errors.Join(errors.New("trouble while writing to the journal"), err)
+	}
+
+	return nil
+}
+
+func (j *Journal) close() error {
+	return j.file.Close()
+}
+
+// Makes a path relative to the journal's directory, leaving it alone if that isn't possible
+func (j *Journal) relative(path string) string {
+	if path == "" {
+		return ""
+	}
+
+	rel, err := filepath.Rel(j.dir, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// Reads every record in a directory's journal. A missing journal just has no records
+func readJournal(dir string) (records []JournalRecord, err error) {
+	path := filepath.Join(dir, loupeDir, journalName)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Join(errors.New("trouble while opening the journal \""+path+"\""), err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record JournalRecord
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			return nil, errors.Join(errors.New("the journal \""+path+"\" is damaged"), err)
+		}
+
+		// Resolve the stored paths against the directory we were given
+		record.From = resolve(dir, record.From)
+		record.To = resolve(dir, record.To)
+		record.Path = resolve(dir, record.Path)
+
+		records = append(records, record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Join(errors.New("trouble while reading the journal \""+path+"\""), err)
+	}
+
+	return records, nil
+}
+
+func resolve(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// Every record from one run of a command, pulled back together
+type JournalRun struct {
+	id         string
+	command    string
+	undoes     string
+	planned    []Action     // The whole plan, in step order
+	done       []Action     // Every action that happened, in the order it happened
+	doneSteps  map[int]bool // The steps of the plan that happened
+	manifested int          // How many of the done actions the manifest has caught up with
+	committed  bool
+}
+
+// A run that wrote down a plan but never finished it
+func (r *JournalRun) incomplete() bool {
+	return len(r.planned) > 0 && !r.committed
+}
+
+// Groups journal records into runs, in the order the runs were started
+func journalRuns(records []JournalRecord) (runs []*JournalRun) {
+	byID := make(map[string]*JournalRun)
+	for _, record := range records {
+		run, ok := byID[record.Run]
+		if !ok {
+			run = &JournalRun{
+				id:        record.Run,
+				command:   record.Command,
+				undoes:    record.Undoes,
+				doneSteps: make(map[int]bool),
+			}
+			byID[record.Run] = run
+			runs = append(runs, run)
+		}
+
+		switch record.State {
+		case statePlanned:
+			run.planned = append(run.planned, record.Action)
+		case stateManifest:
+			run.manifested = len(run.done)
+		case stateCommitted:
+			// The manifest is always caught up before a run is committed
+			run.committed = true
+			run.manifested = len(run.done)
+		default:
+			run.done = append(run.done, record.Action)
+			run.doneSteps[record.Step] = true
+		}
+	}
+	return
+}
@@ -0,0 +1,163 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	listfile.go
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+/*
+	The roll registry and the catalogue are plain text files in the _loupe folder, meant to be
+	edited by hand as much as by Loupe. Each line is a few fields separated by |, and the last
+	field is everything after the | in front of it, so it can hold one too. Blank lines and lines
+	starting with # are left alone. Writing one back only changes the lines of the entries that
+	changed, so comments and the order they were written in stay the way they were. New entries
+	go in before the first entry that sorts after them.
+*/
+
+// A hand edited list of entries in the _loupe folder
+type ListFile struct {
+	path   string
+	what   string   // What the file is called in messages, like "the roll registry"
+	fields int      // How many fields a line is split into
+	lines  []string // Every line as it was read, comments and all
+}
+
+// Reads a list file, or starts a new one with the header if there isn't one yet
+func readListFile(dir, name, what, header string, fields int) (*ListFile, error) {
+	list := &ListFile{path: filepath.Join(dir, loupeDir, name), what: what, fields: fields}
+
+	file, err := os.Open(list.path)
+	if os.IsNotExist(err) {
+		list.lines = strings.Split(strings.TrimSuffix(header, "\n"), "\n")
+		return list, nil
+	}
+	if err != nil {
+		return nil, errors.Join(errors.New("trouble opening "+what), err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		list.lines = append(list.lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Join(errors.New("trouble reading "+what), err)
+	}
+	return list, nil
+}
+
+// True if a line holds no entry
+func isListComment(line string) bool {
+	line = strings.TrimSpace(line)
+	return line == "" || strings.HasPrefix(line, "#")
+}
+
+// Splits a line into its fields, filling in the ones left off at the end as empty
+func (l *ListFile) split(line string) []string {
+	fields := strings.SplitN(strings.TrimSpace(line), "|", l.fields)
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	for len(fields) < l.fields {
+		fields = append(fields, "")
+	}
+	return fields
+}
+
+// Calls read with the fields of every entry in the order they were written. Every problem read
+// returns is gathered up and returned together, marked with the line it was found on
+func (l *ListFile) entries(read func(fields []string) error) error {
+	var problems []error
+	for i, line := range l.lines {
+		if isListComment(line) {
+			continue
+		}
+		if err := read(l.split(line)); err != nil {
+			problems = append(problems, errors.Join(errors.New(fmt.Sprintf("line %d of %s", i+1, l.what)), err))
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// Writes the entries back in place of the ones read, to a temporary file first so it is never
+// left half written. Key tells the entries apart, and orders the new ones
+func (l *ListFile) write(entries [][]string, key func(fields []string) string) error {
+	err := os.MkdirAll(filepath.Dir(l.path), 0755)
+	if err != nil {
+		return errors.Join(errors.New("trouble creating \""+filepath.Dir(l.path)+"\""), err)
+	}
+
+	byKey := make(map[string][]string)
+	for _, entry := range entries {
+		byKey[key(entry)] = entry
+	}
+
+	// Replace the entries already in the file where they are, and drop the ones that are gone
+	var lines []string
+	var keys []string // The key of every line, empty for comments
+	for _, line := range l.lines {
+		if isListComment(line) {
+			lines = append(lines, line)
+			keys = append(keys, "")
+			continue
+		}
+
+		fields := l.split(line)
+		k := key(fields)
+		entry, ok := byKey[k]
+		if !ok {
+			continue
+		}
+
+		// An entry that didn't change keeps the line it was written as
+		if !slices.Equal(fields, entry) {
+			line = formatListEntry(entry)
+		}
+		lines = append(lines, line)
+		keys = append(keys, k)
+		delete(byKey, k)
+	}
+
+	// Put each new entry before the first one that sorts after it
+	var added []string
+	for k := range byKey {
+		added = append(added, k)
+	}
+	slices.Sort(added)
+	for _, k := range added {
+		i := slices.IndexFunc(keys, func(other string) bool {
+			return other != "" && other > k
+		})
+		if i == -1 {
+			i = len(lines)
+		}
+		lines = slices.Insert(lines, i, formatListEntry(byKey[k]))
+		keys = slices.Insert(keys, i, k)
+	}
+
+	err = os.WriteFile(l.path+".tmp", []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		return errors.Join(errors.New("trouble writing "+l.what), err)
+	}
+	l.lines = lines
+	return os.Rename(l.path+".tmp", l.path)
+}
+
+// Writes an entry as a line, leaving off the fields at the end that are empty
+func formatListEntry(fields []string) string {
+	for len(fields) > 1 && fields[len(fields)-1] == "" {
+		fields = fields[:len(fields)-1]
+	}
+	return strings.Join(fields, " | ")
+}
@@ -0,0 +1,127 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	listfile_test.go
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Writing the registry back only touches the rolls that changed, keeping comments where they were
+func TestWriteRollsKeepsComments(t *testing.T) {
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, loupeDir), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := strings.Join([]string{
+		"# My film, newest rolls at the bottom",
+		"",
+		"# Summer trip",
+		"20230615-B | Kodak Portra 400 | Nikon F3",
+		"20230620-A|Ilford HP5|Nikon F3|1600||pushed, see | notes",
+		"",
+		"# Winter",
+		"20231201-A | Kodak Tri-X 400",
+	}, "\n") + "\n"
+	path := filepath.Join(dir, loupeDir, rollsName)
+	err = os.WriteFile(path, []byte(written), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry, list, err := readRolls(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(registry) != 3 || registry[1].Notes != "pushed, see | notes" {
+		t.Fatalf("read %+v", registry)
+	}
+
+	registry, err = addRoll(registry, "20230615-B", Roll{ISO: "400"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, err = addRoll(registry, "20230701-C", Roll{Stock: "Kodak Ektar 100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, err = addRoll(registry, "20240101-A", Roll{Stock: "Fuji C200"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeRolls(list, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"# My film, newest rolls at the bottom",
+		"",
+		"# Summer trip",
+		"20230615-B | Kodak Portra 400 | Nikon F3 | 400",
+		"20230620-A|Ilford HP5|Nikon F3|1600||pushed, see | notes",
+		"",
+		"# Winter",
+		"20230701-C | Kodak Ektar 100",
+		"20231201-A | Kodak Tri-X 400",
+		"20240101-A | Fuji C200",
+	}, "\n") + "\n"
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("wrote\n%s\nexpected\n%s", got, want)
+	}
+}
+
+func TestReadRollsProblems(t *testing.T) {
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, loupeDir), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := "20230615-B | Kodak Portra 400\n# fine\n20231301-A | bad date\n20230615-B | again\n"
+	err = os.WriteFile(filepath.Join(dir, loupeDir, rollsName), []byte(written), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = readRolls(dir)
+	if err == nil {
+		t.Fatal("expected the registry to be refused")
+	}
+	for _, line := range []string{"line 3 of the roll registry", "line 4 of the roll registry"} {
+		if !strings.Contains(err.Error(), line) {
+			t.Errorf("%q doesn't mention %s", err, line)
+		}
+	}
+}
+
+func TestCheckOrphanedRolls(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "granite", "masters", "20230615-B01_granite_master.tif"))
+	_, list, err := readRolls(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeRolls(list, []Roll{{Roll: "20230615-B"}, {Roll: "20230615-C"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := check(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems != 1 {
+		t.Errorf("check found %d problem(s), expected only roll 20230615-C", problems)
+	}
+}
@@ -0,0 +1,276 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	locate.go
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/karlramberg/loupe/photo"
+)
+
+/*
+	The catalogue is a plain text file at _loupe/locations.txt recording where the physical side
+	of a photograph is kept, like the negative or a print. Each line is an identifier, what kind
+	of object it is and where it is, separated by |, like
+
+		20230615-B07 | negative | Binder 3, sleeve 12
+		20230615-B07 | print | Print box 2
+
+	An identifier can have one location for every kind of object. Lines starting with # are
+	comments, and the location is everything after the second |, so it can hold one too. Like
+	the roll registry, it is meant to be read and edited by hand as much as with loupe locate,
+	which keeps the comments and order it finds.
+*/
+
+const locationsName = "locations.txt"
+
+const locationsHeader = `# Loupe catalogue of physical objects, one object per line:
+# identifier | kind | location
+`
+
+// Where one physical object of a photograph is kept
+type Location struct {
+	Identifier string `json:"identifier"`
+	Kind       string `json:"kind"`
+	Location   string `json:"location"`
+}
+
+// Reads the catalogue of an archive, along with the file so it can be written back. An archive
+// without one has nothing located
+func readLocations(dir string) ([]Location, *ListFile, error) {
+	list, err := readListFile(dir, locationsName, "the catalogue", locationsHeader, 3)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var locations []Location
+	err = list.entries(func(fields []string) error {
+		location := Location{fields[0], fields[1], fields[2]}
+		if err := checkLocation(location); err != nil {
+			return err
+		}
+		if findLocation(locations, location.Identifier, location.Kind) != -1 {
+			return errors.New("the " + location.Kind + " of " + location.Identifier + " is repeated")
+		}
+		locations = append(locations, location)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return locations, list, nil
+}
+
+// Writes the catalogue back, leaving every comment and location that didn't change as it was
+func writeLocations(list *ListFile, locations []Location) error {
+	entries := make([][]string, len(locations))
+	for i, location := range locations {
+		entries[i] = []string{location.Identifier, location.Kind, location.Location}
+	}
+
+	// Orders by identifier and then by kind, since nothing sorts before the zero byte
+	return list.write(entries, func(fields []string) string {
+		return fields[0] + "\x00" + fields[1]
+	})
+}
+
+// Finds the location of one kind of object of an identifier, or -1 if there isn't one
+func findLocation(locations []Location, identifier, kind string) int {
+	return slices.IndexFunc(locations, func(l Location) bool {
+		return l.Identifier == identifier && l.Kind == kind
+	})
+}
+
+// Checks the identifier and kind of a location, and that there is a location at all
+func checkLocation(location Location) error {
+	if valid, err := photo.ValidIdentifier(location.Identifier); !valid {
+		return errors.Join(errors.New("\""+location.Identifier+"\" isn't an identifier"), err)
+	}
+	if valid, err := photo.ValidWord(location.Kind); !valid {
+		return errors.Join(errors.New("\""+location.Kind+"\" isn't a kind of object, use a word like negative or print"), err)
+	}
+	if location.Location == "" {
+		return errors.New("the " + location.Kind + " of " + location.Identifier + " has no location")
+	}
+	return nil
+}
+
+// True if an identifier is the one asked for, or is on the roll or date asked for. Roll letters
+// are capitals in identifiers, so the query is too
+func identifierMatches(identifier, query string) bool {
+	query = strings.ToUpper(query)
+	date, number, _ := strings.Cut(identifier, "-")
+	if identifier == query || date == query {
+		return true
+	}
+
+	// Identifiers without a letter aren't on a roll, so there is no roll to match
+	letter := strings.TrimRight(number, "0123456789")
+	return letter != "" && date+"-"+letter == query
+}
+
+// Records, looks up and lists where the physical objects of photographs are kept. With add set, the
+// kind of object of that identifier is put at the location given. With missing set, the identifiers
+// with files in the archive but no location are listed instead. Otherwise the locations of the
+// identifiers, rolls or dates queried are listed, or every location if there is no query
+func locate(dir, add, kind, at string, missing bool, queries []string, format string) error {
+	// Check that the -a flag was used
+	if dir == "" {
+		return errors.New("provide an archive directory using the -a flag")
+	}
+
+	// Check that the given directory exists
+	stats, err := os.Stat(dir)
+	if os.IsNotExist(err) || !stats.IsDir() {
+		return errors.New("directory \"" + dir + "\" not found")
+	}
+
+	if format != "table" && format != "json" {
+		return errors.New("invalid format. Use table or json")
+	}
+
+	locations, list, err := readLocations(dir)
+	if err != nil {
+		return err
+	}
+
+	// Roll letters are always capitals and kinds are always lowercase, like in a filename
+	add, kind = strings.ToUpper(add), strings.ToLower(kind)
+
+	if add != "" {
+		return addLocation(list, locations, Location{add, kind, strings.TrimSpace(at)})
+	}
+
+	if missing {
+		return missingLocations(dir, locations, kind, format)
+	}
+
+	// Look up the locations asked for, of every kind unless one was given
+	found := []Location{}
+	for _, location := range locations {
+		if kind != "" && location.Kind != kind {
+			continue
+		}
+		if len(queries) > 0 && !slices.ContainsFunc(queries, func(query string) bool {
+			return identifierMatches(location.Identifier, query)
+		}) {
+			continue
+		}
+		found = append(found, location)
+	}
+
+	if format == "json" {
+		return writeLocationsJSON(found)
+	}
+
+	fmt.Println("Loupe", loupeVersion, "-", "Locate")
+	var identifierWidth, kindWidth int
+	for _, location := range found {
+		identifierWidth = max(identifierWidth, len(location.Identifier))
+		kindWidth = max(kindWidth, len(location.Kind))
+	}
+	for _, location := range found {
+		fmt.Printf("%-*s  %-*s  %s\n", identifierWidth, location.Identifier, kindWidth, location.Kind, location.Location)
+	}
+	fmt.Println(len(found), "location(s)")
+	return nil
+}
+
+// Puts a kind of object of an identifier at a location in the catalogue, replacing where it was
+func addLocation(list *ListFile, locations []Location, location Location) error {
+	if location.Kind == "" {
+		return errors.New("provide the kind of object using the -kind flag, like negative or print")
+	}
+	if location.Location == "" {
+		return errors.New("provide where the " + location.Kind + " is using the -at flag")
+	}
+	if strings.Contains(location.Location, "\n") {
+		return errors.New("the location can't hold a new line")
+	}
+	err := checkLocation(location)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Loupe", loupeVersion, "-", "Locate")
+	if i := findLocation(locations, location.Identifier, location.Kind); i != -1 {
+		fmt.Println("The", location.Kind, "of", location.Identifier, "moved from", locations[i].Location, "to", location.Location)
+		locations[i] = location
+	} else {
+		fmt.Println("The", location.Kind, "of", location.Identifier, "is at", location.Location)
+		locations = append(locations, location)
+	}
+	return writeLocations(list, locations)
+}
+
+// Lists every identifier with files in the archive and no location, or no location of the kind
+func missingLocations(dir string, locations []Location, kind, format string) error {
+	// Get a list of image files in the directory and its subdirectories, from the index if there is one
+	files, err := getArchiveFiles(dir, false)
+	if err != nil {
+		return errors.Join(errors.New("trouble getting image files from \""+dir+"\""), err)
+	}
+
+	// Marking the identifiers that are listed as located too keeps each one from being listed twice
+	located := make(map[string]bool)
+	for _, location := range locations {
+		if kind == "" || location.Kind == kind {
+			located[location.Identifier] = true
+		}
+	}
+
+	found := []string{}
+	for _, file := range files {
+		if file.Err != nil {
+			continue
+		}
+		identifier := file.Photograph.Identifier()
+		if !located[identifier] {
+			found = append(found, identifier)
+			located[identifier] = true
+		}
+	}
+	slices.Sort(found)
+
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "\t")
+		err := encoder.Encode(found)
+		if err != nil {
+			return errors.Join(errors.New("trouble while writing the identifiers"), err)
+		}
+		return nil
+	}
+
+	object := "physical object"
+	if kind != "" {
+		object = kind
+	}
+
+	fmt.Println("Loupe", loupeVersion, "-", "Locate")
+	for _, identifier := range found {
+		fmt.Println(identifier)
+	}
+	fmt.Println(len(found), "identifier(s) with files but no", object, "located")
+	return nil
+}
+
+// Writes locations to standard output as a JSON array
+func writeLocationsJSON(found []Location) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "\t")
+	err := encoder.Encode(found)
+	if err != nil {
+		return errors.Join(errors.New("trouble while writing the locations"), err)
+	}
+	return nil
+}
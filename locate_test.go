@@ -0,0 +1,108 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	locate_test.go
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIdentifierMatches(t *testing.T) {
+	tests := []struct {
+		identifier string
+		query      string
+		want       bool
+	}{
+		{"20230615-B07", "20230615-B07", true},
+		{"20230615-B07", "20230615-b07", true},
+		{"20230615-B07", "20230615-B", true},
+		{"20230615-B07", "20230615-b", true},
+		{"20230615-B07", "20230615", true},
+		{"20230615-B07", "20230615-A", false},
+		{"20230615-B07", "20230615-B0", false},
+		{"20230615-ZA99100", "20230615-za", true},
+		{"20230615-ZA99100", "20230615-Z", false},
+		{"20230615-007", "20230615", true},
+		{"20230615-007", "20230615-", false},
+		{"20230615-007", "20230615-007", true},
+		{"20230615-007", "20230616", false},
+	}
+
+	for _, test := range tests {
+		if got := identifierMatches(test.identifier, test.query); got != test.want {
+			t.Errorf("identifierMatches(%q, %q) = %v, expected %v", test.identifier, test.query, got, test.want)
+		}
+	}
+}
+
+// Adding a location keeps the comments and puts it with the others of its identifier
+func TestWriteLocationsKeepsComments(t *testing.T) {
+	dir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(dir, loupeDir), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := strings.Join([]string{
+		"# Binder 3 is in the hall closet",
+		"20230615-B07 | print | Print box 2",
+		"20230615-B08 | negative | Binder 3, sleeve 12",
+	}, "\n") + "\n"
+	path := filepath.Join(dir, loupeDir, locationsName)
+	err = os.WriteFile(path, []byte(written), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locations, list, err := readLocations(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = addLocation(list, locations, Location{"20230615-B07", "negative", "Binder 3, sleeve 12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"# Binder 3 is in the hall closet",
+		"20230615-B07 | negative | Binder 3, sleeve 12",
+		"20230615-B07 | print | Print box 2",
+		"20230615-B08 | negative | Binder 3, sleeve 12",
+	}, "\n") + "\n"
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("wrote\n%s\nexpected\n%s", got, want)
+	}
+}
+
+func TestCheckOrphanedLocations(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "granite", "masters", "20230101-001_granite_master.tif"))
+	_, list, err := readLocations(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeLocations(list, []Location{
+		{"20230101-001", "print", "Print box 1"},
+		{"20230101-002", "print", "Print box 1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := check(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems != 1 {
+		t.Errorf("check found %d problem(s), expected only the print of 20230101-002", problems)
+	}
+}
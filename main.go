@@ -12,12 +12,12 @@
 /*
 TODO for v1
 [ ] Commands for renaming attributes (refactor)
-[ ] Commands for changing selected identifiers attributes (modify)
-[ ] Help command
+[x] Commands for changing selected identifiers attributes (modify)
+[x] Help command
 [ ] Really solid printing output
 [ ] Robust error messages
 [ ] Good comments for future Karl
-[ ] "print" commands, prints a nice table of valid photos sorted by identifier (good to see a timeline of your work from
+[x] "print" commands, prints a nice table of valid photos sorted by identifier (good to see a timeline of your work from
 	start to present)
 [x] Clean-up init() in particular
 [x] File clean-up, possible split into multiple (cli and actual data)
@@ -27,16 +27,17 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"io/fs"
 	"os"
-	"path/filepath"
 	"regexp"
 	"slices"
 	"strconv"
 	"strings"
+
+	"github.com/karlramberg/loupe/photo"
 )
 
 const loupeVersion string = "v0.1.0"
@@ -55,24 +56,15 @@ var imageExtensions = []string{
 
 // Walks through a directory, creating a list of image files
 func getImageFiles(dir string) (files []string, err error) {
-	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
-		// Ignore any folder that starts with an underscore
-		if d.IsDir() && d.Name()[0] == '_' {
-			return filepath.SkipDir
-		}
-
-		// Only save files that are an image
-		ext := strings.ToLower(filepath.Ext(path))
-		if slices.Contains(imageExtensions, ext) || slices.Contains(rawExtensions, ext) {
-			files = append(files, path)
-		}
-
-		return nil
-	})
-	if err != nil {
-		return nil, errors.Join(errors.New("there was trouble reading files from the directory"), err)
+	scanned, errs := scanImageFiles(context.Background(), dir)
+	for file := range scanned {
+		files = append(files, file.Path)
+	}
+	if err := <-errs; err != nil {
+		return nil, err
 	}
 
+	slices.SortFunc(files, comparePaths)
 	return
 }
 
@@ -110,12 +102,24 @@ func promptInput(scanner *bufio.Scanner, prompt, defaultInput string) (string, e
 	return scanner.Text(), nil
 }
 
+// Prompts for a selection until a valid one is given. Only an error reading the input is returned
 func promptSelection(scanner *bufio.Scanner, length int) ([]int, error) {
-	input, err := promptInput(scanner, "Select files", "all")
-	if err != nil {
-		return nil, err
+	for {
+		input, err := promptInput(scanner, "Select files", "all")
+		if err != nil {
+			return nil, err
+		}
+
+		selections, err := parseSelection(input, length)
+		if err == nil {
+			return selections, nil
+		}
+		fmt.Println("Error:", err)
 	}
+}
 
+// Turns a selection expression into a slice of indices, "all" selects every index below length
+func parseSelection(input string, length int) ([]int, error) {
 	if input == "all" {
 		return makeRange(0, length-1), nil
 	}
@@ -187,56 +191,38 @@ func cleanSelection(dirty []int, min, max int) (clean []int) {
 	return clean
 }
 
-// Prompts for a number or a letter/number (e.g. 007 or B07)
-func promptNumber(scanner *bufio.Scanner) (string, string, error) {
-	input, err := promptInput(scanner, "Enter roll letter", "none")
-	if err != nil {
-		return "", "", err
+// Checks a roll letter, which is either capital letters or "none". Lowercase letters are capitalized
+func checkLetter(letter string) (string, error) {
+	if letter == "none" {
+		return letter, nil
 	}
 
-	padding := 3
-	if input != "none" {
-		padding = 2
-
-		// Check that the given letter is only capital letters
-		input = strings.ToUpper(input)
-		valid, err := regexp.MatchString("^([A-Z]+)$", input)
-		if !valid || err != nil {
-			return "", "", errors.Join(errors.New("invalid roll letter. Only use capital letter"), err)
-		}
+	letter = strings.ToUpper(letter)
+	valid, err := photo.ValidLetter(letter)
+	if !valid {
+		return "", err
 	}
-	letter := input
 
-	// Grab input for the starting number
-	input, err = promptInput(scanner, "Enter start number", "1")
-	if err != nil {
-		return "", "", err
-	}
+	return letter, nil
+}
 
-	// Check that the number is only digits
-	valid, err := regexp.MatchString("^([0-9]+)$", input)
+// Checks that a start number is only digits
+func checkStart(start string) (string, error) {
+	valid, err := regexp.MatchString("^([0-9]+)$", start)
 	if !valid || err != nil {
-		return "", "", errors.Join(errors.New("invalid start number. Only use a whole number"), err)
+		return "", errors.Join(errors.New("invalid start number. Only use a whole number"), err)
 	}
 
-	// %0*s pads input with zeros so the has length of pad
-	number := fmt.Sprintf("%0*s", padding, input)
-
-	return letter, number, nil
+	return start, nil
 }
 
-// Prompts for a date from the user
-func promptDate(scanner *bufio.Scanner, defaultDate string) (string, error) {
-	date, err := promptInput(scanner, "Enter date", defaultDate)
-	if err != nil {
-		return "", nil
-	}
-
+// Checks a date, which is either formatted YYYYMMDD or "auto"
+func checkDate(date string) (string, error) {
 	if date == "auto" {
 		return date, nil
 	}
 
-	valid, err := validDate(date)
+	valid, err := photo.ValidDate(date)
 	if !valid {
 		return "", err
 	}
@@ -244,16 +230,11 @@ func promptDate(scanner *bufio.Scanner, defaultDate string) (string, error) {
 	return date, nil
 }
 
-// Prompts for a lowercase alphanumeric word. Used for class, group, version and subversion
-func promptWord(scanner *bufio.Scanner, prompt, defaultWord string) (string, error) {
-	word, err := promptInput(scanner, prompt, defaultWord)
-	if err != nil {
-		return "", err
-	}
-
+// Checks a class, group, version or subversion. Uppercase letters are lowercased
+func checkWord(word string) (string, error) {
 	word = strings.ToLower(word)
 
-	valid, err := validWord(word)
+	valid, err := photo.ValidWord(word)
 	if !valid || err != nil {
 		return "", err
 	}
@@ -261,6 +242,24 @@ func promptWord(scanner *bufio.Scanner, prompt, defaultWord string) (string, err
 	return word, nil
 }
 
+// Prompts for a value until one passes the check, telling the user what was wrong with each one
+// that doesn't. Used for every attribute of a filename. Only an error reading the input is
+// returned, so a closed or broken input can't keep the prompt going forever
+func promptChecked(scanner *bufio.Scanner, prompt, defaultInput string, check func(string) (string, error)) (string, error) {
+	for {
+		input, err := promptInput(scanner, prompt, defaultInput)
+		if err != nil {
+			return "", err
+		}
+
+		value, err := check(input)
+		if err == nil {
+			return value, nil
+		}
+		fmt.Println("Invalid:", err)
+	}
+}
+
 // Prompts for a basic confirmation. True if the first character entered was a y, otherwise false
 func promptConfimation(scanner *bufio.Scanner, message string) (bool, error) {
 	input, err := promptInput(scanner, message, "no")
@@ -278,19 +277,120 @@ func promptConfimation(scanner *bufio.Scanner, message string) (bool, error) {
 func main() {
 	nameCmd := flag.NewFlagSet("name", flag.ExitOnError)
 	nameDir := nameCmd.String("w", "", "Working directory")
+	nameDryRun := nameCmd.Bool("dry-run", false, "Print the planned changes without making them")
+	nameJSON := nameCmd.String("json", "", "Write the planned changes to a JSON file")
+	nameSelect := nameCmd.String("select", "", "Files to name, a selection expression like 1-5,14 or all")
+	nameDate := nameCmd.String("date", "", "Date shot, YYYYMMDD or auto")
+	nameRoll := nameCmd.String("roll", "", "Roll letter or none")
+	nameStart := nameCmd.String("start", "", "Number to start counting from")
+	nameClass := nameCmd.String("class", "", "Class or none")
+	nameGroup := nameCmd.String("group", "", "Group")
+	nameVersion := nameCmd.String("version", "", "Version")
+	nameSubversion := nameCmd.String("subversion", "", "Subversion or none")
+	nameYes := nameCmd.Bool("yes", false, "Use the defaults for anything not given and skip the confirmation")
+	nameBatch := nameCmd.String("batch", "", "CSV or JSON file giving the attributes of each file")
+	nameArchive := nameCmd.String("a", "", "Archive directory to continue numbering after")
+
+	printCmd := flag.NewFlagSet("print", flag.ExitOnError)
+	printDir := printCmd.String("a", "", "Archive directory")
+	printFrom := printCmd.String("from", "", "Only photographs shot on or after this date, YYYYMMDD")
+	printTo := printCmd.String("to", "", "Only photographs shot on or before this date, YYYYMMDD")
+	printClass := printCmd.String("class", "", "Only photographs in this class")
+	printGroup := printCmd.String("group", "", "Only photographs in this group")
+	printFormat := printCmd.String("format", "table", "Output format: table, csv or json")
+
+	checkCmd := flag.NewFlagSet("check", flag.ExitOnError)
+	checkDir := checkCmd.String("a", "", "Archive directory")
+
+	ingestCmd := flag.NewFlagSet("ingest", flag.ExitOnError)
+	ingestWork := ingestCmd.String("w", "", "Working directory")
+	ingestArchive := ingestCmd.String("a", "", "Archive directory")
+	ingestKeep := ingestCmd.Bool("keep", false, "Keep the files in the working directory after they are copied")
+	ingestDryRun := ingestCmd.Bool("dry-run", false, "Print the planned changes without making them")
+	ingestJSON := ingestCmd.String("json", "", "Write the planned changes to a JSON file")
+
+	dupesCmd := flag.NewFlagSet("dupes", flag.ExitOnError)
+	dupesDir := dupesCmd.String("a", "", "Archive directory")
+	dupesRemove := dupesCmd.Bool("remove", false, "Delete every exact duplicate sharing a name or identifier, keeping one copy")
+	dupesAcrossNames := dupesCmd.Bool("across-names", false, "Also delete exact duplicates under another name with -remove")
+	dupesDryRun := dupesCmd.Bool("dry-run", false, "Print the planned changes without making them")
+	dupesJSON := dupesCmd.String("json", "", "Write the planned changes to a JSON file")
+
+	hashCmd := flag.NewFlagSet("hash", flag.ExitOnError)
+	hashDir := hashCmd.String("a", "", "Archive directory")
+	hashRehash := hashCmd.Bool("rehash", false, "Hash every file again, replacing the checksums already in the manifest")
+
+	verifyCmd := flag.NewFlagSet("verify", flag.ExitOnError)
+	verifyDir := verifyCmd.String("a", "", "Archive directory")
+
+	findCmd := flag.NewFlagSet("find", flag.ExitOnError)
+	findDir := findCmd.String("a", "", "Archive directory")
+	findFormat := findCmd.String("format", "paths", "Output format: paths, table or json")
+
+	gapsCmd := flag.NewFlagSet("gaps", flag.ExitOnError)
+	gapsDir := gapsCmd.String("a", "", "Archive directory")
+	gapsHave := gapsCmd.String("have", "", "Only identifiers with one of these versions, separated by commas")
+	gapsMissing := gapsCmd.String("missing", "", "List identifiers without any of these versions, separated by commas")
+	gapsFormat := gapsCmd.String("format", "table", "Output format: table, csv or json")
+
+	rollsCmd := flag.NewFlagSet("rolls", flag.ExitOnError)
+	rollsDir := rollsCmd.String("a", "", "Archive directory")
+	rollsAdd := rollsCmd.String("add", "", "Add a roll to the registry or change its details, like 20230615-B")
+	rollsStock := rollsCmd.String("stock", "", "Film stock of the roll being added")
+	rollsCamera := rollsCmd.String("camera", "", "Camera the roll being added was shot with")
+	rollsISO := rollsCmd.String("iso", "", "ISO the roll being added was shot at")
+	rollsDeveloper := rollsCmd.String("developer", "", "Developer the roll being added was processed in")
+	rollsNotes := rollsCmd.String("notes", "", "Notes about the roll being added")
+	rollsFormat := rollsCmd.String("format", "table", "Output format: table or json")
+
+	locateCmd := flag.NewFlagSet("locate", flag.ExitOnError)
+	locateDir := locateCmd.String("a", "", "Archive directory")
+	locateAdd := locateCmd.String("add", "", "Identifier to record the location of an object for")
+	locateKind := locateCmd.String("kind", "", "Kind of physical object, like negative or print")
+	locateAt := locateCmd.String("at", "", "Where the object being added is kept")
+	locateMissing := locateCmd.Bool("missing", false, "List identifiers with files but no location recorded")
+	locateFormat := locateCmd.String("format", "table", "Output format: table or json")
+
+	serveCmd := flag.NewFlagSet("serve", flag.ExitOnError)
+	serveDir := serveCmd.String("a", "", "Archive directory")
+	serveAddr := serveCmd.String("addr", "localhost:8080", "Address to serve on")
+
+	indexCmd := flag.NewFlagSet("index", flag.ExitOnError)
+	indexDir := indexCmd.String("a", "", "Archive directory")
+	indexRebuild := indexCmd.Bool("rebuild", false, "Throw the index away and read every folder again")
 
 	refactorCmd := flag.NewFlagSet("refactor", flag.ExitOnError)
 	refactorDir := refactorCmd.String("a", "", "Archive directory")
 	refactorType := refactorCmd.String("t", "", "Group type")
 	refactorOld := refactorCmd.String("o", "", "Old group name")
 	refactorNew := refactorCmd.String("n", "", "New group name")
+	refactorPick := refactorCmd.Bool("pick", false, "Pick which of the matching files to rename")
+	refactorDryRun := refactorCmd.Bool("dry-run", false, "Print the planned changes without making them")
+	refactorJSON := refactorCmd.String("json", "", "Write the planned changes to a JSON file")
 
 	sortCmd := flag.NewFlagSet("sort", flag.ExitOnError)
 	sortDir := sortCmd.String("a", "", "Archive directory")
+	sortDryRun := sortCmd.Bool("dry-run", false, "Print the planned changes without making them")
+	sortJSON := sortCmd.String("json", "", "Write the planned changes to a JSON file")
+	sortRemoveDupes := sortCmd.Bool("remove-dupes", false, "Delete files identical to the file already at their destination")
+
+	modifyCmd := flag.NewFlagSet("modify", flag.ExitOnError)
+	modifyDir := modifyCmd.String("w", "", "Working directory")
+	modifyDryRun := modifyCmd.Bool("dry-run", false, "Print the planned changes without making them")
+	modifyJSON := modifyCmd.String("json", "", "Write the planned changes to a JSON file")
+
+	undoCmd := flag.NewFlagSet("undo", flag.ExitOnError)
+	undoDir := undoCmd.String("a", "", "Archive directory")
+	undoDryRun := undoCmd.Bool("dry-run", false, "Print the planned changes without making them")
+	undoJSON := undoCmd.String("json", "", "Write the planned changes to a JSON file")
+
+	// Every command prints its own help with -h
+	cmds := []*flag.FlagSet{nameCmd, modifyCmd, ingestCmd, sortCmd, refactorCmd, printCmd, findCmd, gapsCmd, rollsCmd, locateCmd, serveCmd, checkCmd, dupesCmd, hashCmd, verifyCmd, indexCmd, undoCmd}
+	setUsage(cmds)
 
 	if len(os.Args) < 2 {
 		fmt.Println("Loupe", loupeVersion)
-		fmt.Println("Error: no subcommand provided")
+		fmt.Println("Error: no subcommand provided, run \"loupe help\" for a list of commands")
 		return
 	}
 
@@ -299,7 +399,141 @@ func main() {
 	// Name images in Loupe's format from scratch, ignoring any previous filenames
 	case "name":
 		nameCmd.Parse(os.Args[2:])
-		err := name(*nameDir)
+		opts := NameOptions{
+			selection:  *nameSelect,
+			date:       *nameDate,
+			roll:       *nameRoll,
+			start:      *nameStart,
+			class:      *nameClass,
+			group:      *nameGroup,
+			version:    *nameVersion,
+			subversion: *nameSubversion,
+			yes:        *nameYes,
+			batch:      *nameBatch,
+			archive:    *nameArchive,
+		}
+		err := name(*nameDir, opts, *nameDryRun, *nameJSON)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+
+	// Change the identifier or groupings of selected photographs
+	case "modify":
+		modifyCmd.Parse(os.Args[2:])
+		err := modify(*modifyDir, *modifyDryRun, *modifyJSON)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+
+	// Print a timeline of every photograph in the archive, ordered by identifier
+	case "print":
+		printCmd.Parse(os.Args[2:])
+		filter := TimelineFilter{from: *printFrom, to: *printTo, class: *printClass, group: *printGroup}
+		err := printTimeline(*printDir, filter, *printFormat)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+
+	// Report anything in the archive that breaks Loupe's rules. Exits with 1 if there were problems
+	// and 2 if the check couldn't be done, so scripts can tell them apart
+	case "check":
+		checkCmd.Parse(os.Args[2:])
+		problems, err := check(*checkDir)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(2)
+		}
+		if problems > 0 {
+			os.Exit(1)
+		}
+
+	// Copy named photographs from a working directory into their folders in the archive
+	case "ingest":
+		ingestCmd.Parse(os.Args[2:])
+		err := ingest(*ingestWork, *ingestArchive, *ingestKeep, *ingestDryRun, *ingestJSON)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+
+	// Find files with the same contents, and names shared by different files
+	case "dupes":
+		dupesCmd.Parse(os.Args[2:])
+		err := dupes(*dupesDir, *dupesRemove, *dupesAcrossNames, *dupesDryRun, *dupesJSON)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+
+	// Record a checksum of every file in the archive
+	case "hash":
+		hashCmd.Parse(os.Args[2:])
+		err := hash(*hashDir, *hashRehash)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+
+	// Compare every file in the archive to its checksum. Exits like check does
+	case "verify":
+		verifyCmd.Parse(os.Args[2:])
+		problems, err := verify(*verifyDir)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(2)
+		}
+		if problems > 0 {
+			os.Exit(1)
+		}
+
+	// List the files matching a query over their names, everything after the flags is the query
+	case "find":
+		findCmd.Parse(os.Args[2:])
+		err := find(*findDir, findCmd.Args(), *findFormat)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+
+	// List the identifiers that still need a version made
+	case "gaps":
+		gapsCmd.Parse(os.Args[2:])
+		err := gaps(*gapsDir, *gapsHave, *gapsMissing, *gapsFormat)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+
+	// List the rolls of film in the archive, and keep the registry of their details
+	case "rolls":
+		rollsCmd.Parse(os.Args[2:])
+		details := Roll{
+			Stock:     *rollsStock,
+			Camera:    *rollsCamera,
+			ISO:       *rollsISO,
+			Developer: *rollsDeveloper,
+			Notes:     *rollsNotes,
+		}
+		err := rolls(*rollsDir, *rollsAdd, details, *rollsFormat)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+
+	// Record and look up where negatives, prints and other physical objects are kept
+	case "locate":
+		locateCmd.Parse(os.Args[2:])
+		err := locate(*locateDir, *locateAdd, *locateKind, *locateAt, *locateMissing, locateCmd.Args(), *locateFormat)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+
+	// Browse the archive in a web browser, without changing anything
+	case "serve":
+		serveCmd.Parse(os.Args[2:])
+		err := serve(*serveDir, *serveAddr)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+
+	// Keep a record of every file in the archive so reading it is quick
+	case "index":
+		indexCmd.Parse(os.Args[2:])
+		err := index(*indexDir, *indexRebuild)
 		if err != nil {
 			fmt.Println("Error:", err)
 		}
@@ -307,7 +541,7 @@ func main() {
 	// Change the name of a class, group, version or subversion
 	case "refactor":
 		refactorCmd.Parse(os.Args[2:])
-		err := refactor(*refactorDir, *refactorType, *refactorOld, *refactorNew)
+		err := refactor(*refactorDir, *refactorType, *refactorOld, *refactorNew, *refactorPick, *refactorDryRun, *refactorJSON)
 		if err != nil {
 			fmt.Println("Error:", err)
 		}
@@ -316,13 +550,28 @@ func main() {
 	// Invalidly-named images are put into the base folder
 	case "sort":
 		sortCmd.Parse(os.Args[2:])
-		err := sort(*sortDir)
+		err := sort(*sortDir, *sortRemoveDupes, *sortDryRun, *sortJSON)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+
+	// Reverse the last change recorded in the directory's journal
+	case "undo":
+		undoCmd.Parse(os.Args[2:])
+		err := undo(*undoDir, *undoDryRun, *undoJSON)
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+
+	// Print an abridged README, or the flags and examples of one command
+	case "help":
+		err := help(os.Args[2:], cmds)
 		if err != nil {
 			fmt.Println("Error:", err)
 		}
 
 	default:
 		fmt.Println("")
-		fmt.Printf("Error: command \"%s\" not found\n", os.Args[1])
+		fmt.Printf("Error: command \"%s\" not found, run \"loupe help\" for a list of commands\n", os.Args[1])
 	}
 }
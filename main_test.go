@@ -0,0 +1,57 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	main_test.go
+*/
+
+package main
+
+import (
+	"bufio"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/karlramberg/loupe/photo"
+)
+
+// Invalid answers are asked for again, until a valid one or the default is given
+func TestPromptRetries(t *testing.T) {
+	value, err := promptChecked(testScanner("Gran ite", "granite"), "Enter group", "default", checkWord)
+	if err != nil || value != "granite" {
+		t.Errorf("promptChecked returned %q, %v, expected granite", value, err)
+	}
+
+	value, err = promptModification(testScanner("20231301", "20230101"), "Enter date", photo.ValidDate)
+	if err != nil || value != "20230101" {
+		t.Errorf("promptModification returned %q, %v, expected 20230101", value, err)
+	}
+
+	selections, err := promptSelection(testScanner("9", "2-3"), 3)
+	if err != nil || !slices.Equal(selections, []int{1, 2}) {
+		t.Errorf("promptSelection returned %v, %v, expected [1 2]", selections, err)
+	}
+
+	// Once the input runs out, the default is all that is left
+	value, err = promptModification(testScanner("20231301"), "Enter date", photo.ValidDate)
+	if err != nil || value != "keep" {
+		t.Errorf("promptModification returned %q, %v after the input closed, expected keep", value, err)
+	}
+}
+
+// An input that can't be read is returned as an error instead of being asked for again forever
+func TestPromptInputError(t *testing.T) {
+	tooLong := func() *bufio.Scanner {
+		return bufio.NewScanner(strings.NewReader(strings.Repeat("a", bufio.MaxScanTokenSize+1) + "\n"))
+	}
+
+	if _, err := promptChecked(tooLong(), "Enter group", "default", checkWord); err == nil {
+		t.Error("promptChecked didn't return an error for a line too long to read")
+	}
+	if _, err := promptModification(tooLong(), "Enter date", photo.ValidDate); err == nil {
+		t.Error("promptModification didn't return an error for a line too long to read")
+	}
+	if _, err := promptSelection(tooLong(), 3); err == nil {
+		t.Error("promptSelection didn't return an error for a line too long to read")
+	}
+}
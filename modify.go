@@ -0,0 +1,297 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	modify.go
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/karlramberg/loupe/photo"
+)
+
+func modify(dir string, dryRun bool, jsonPath string) error {
+	fmt.Println("Loupe", loupeVersion, "-", "Modify")
+
+	// Check that the -w flag was used
+	if dir == "" {
+		return errors.New("provide a working directory using the -w flag")
+	}
+
+	// Check that the given directory exists
+	stat, err := os.Stat(dir)
+	if os.IsNotExist(err) || !stat.IsDir() {
+		return errors.New("directory \"" + dir + "\" not found")
+	}
+
+	// Setup a scanner to standard input for the user to give values
+	scanner := bufio.NewScanner(os.Stdin)
+
+	// Deal with a change that was cut off before looking at the files it may have moved
+	if !dryRun {
+		okay, err := recoverInterrupted(dir, scanner)
+		if err != nil || !okay {
+			return err
+		}
+	}
+
+	// Get a list of image files in the directory and its subdirectories
+	files, err := getImageFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	// Only validly named photographs can be modified, anything else needs to go through name
+	var photos []photo.Photograph
+	var validFiles []string
+	for _, file := range files {
+		photograph, err := photo.Parse(filepath.Base(file))
+		if err != nil {
+			continue
+		}
+
+		photos = append(photos, photograph)
+		validFiles = append(validFiles, file)
+	}
+
+	if len(photos) == 0 {
+		return errors.New("no validly named photographs found in \"" + dir + "\"")
+	}
+	if len(validFiles) < len(files) {
+		fmt.Println(len(files)-len(validFiles), "invalidly named file(s) left out, use name for those")
+	}
+
+	// The new values come first, so the selector can show the name each file would get
+	fmt.Println("Enter new values, or keep to leave an attribute as it is")
+
+	// Ask the user for a new date string, format YYYYMMDD
+	date, err := promptModification(scanner, "Enter date", photo.ValidDate)
+	if err != nil {
+		return err
+	}
+
+	// Ask the user for a new roll letter, "none" takes the letter away
+	letter, err := promptModification(scanner, "Enter roll letter", validOptionalLetter)
+	if err != nil {
+		return err
+	}
+
+	if letter != "keep" && letter != "none" {
+		letter = strings.ToUpper(letter)
+	}
+
+	// Ask the user for a new start number to count up from
+	start, err := promptModification(scanner, "Enter start number", validStartNumber)
+	if err != nil {
+		return err
+	}
+
+	// Ask the user for a new class, "none" takes the class away
+	class, err := promptModification(scanner, "Enter class", validOptionalWord)
+	if err != nil {
+		return err
+	}
+
+	// Ask the user for a new group
+	group, err := promptModification(scanner, "Enter group", photo.ValidWord)
+	if err != nil {
+		return err
+	}
+
+	// Ask the user for a new version
+	version, err := promptModification(scanner, "Enter version", photo.ValidWord)
+	if err != nil {
+		return err
+	}
+
+	// Ask the user for a new subversion, "none" takes the subversion away
+	subversion, err := promptModification(scanner, "Enter subversion", validOptionalWord)
+	if err != nil {
+		return err
+	}
+
+	// Gives a photograph the new values. New numbers are handed out per identifier in the order
+	// the photographs are selected, so the number to give is passed in
+	modified := func(photograph photo.Photograph, number string) photo.Photograph {
+		if date != "keep" {
+			photograph.Date = date
+		}
+		if letter != "keep" {
+			photograph.Letter = letter
+		}
+		if start != "keep" {
+			photograph.Number = number
+		}
+		if class != "keep" {
+			photograph.Class = class
+		}
+		if group != "keep" {
+			photograph.Group = group
+		}
+		if version != "keep" {
+			photograph.Version = version
+		}
+		if subversion != "keep" {
+			photograph.Subversion = subversion
+		}
+
+		// Pad the number to fit with the roll letter, which may have just been added or taken away
+		padding := photo.NumberWidth(photograph.Letter)
+		photograph.Number = fmt.Sprintf("%0*s", padding, strings.TrimLeft(photograph.Number, "0"))
+		return photograph
+	}
+
+	// Ask the user for a selection of all or some of the photographs, showing the new name of the
+	// one under the cursor. Its new number isn't known until the selection is, so it is left as #
+	selections, err := selectFiles(scanner, validFiles, func(i int) string {
+		photograph := modified(photos[i], "")
+		if start != "keep" {
+			photograph.Number = strings.Repeat("#", photo.NumberWidth(photograph.Letter))
+		}
+		return "New name " + photograph.Filename()
+	})
+	if err != nil {
+		return err
+	}
+
+	// Keep track of which identifiers belong to photographs that aren't being modified
+	selected := make(map[int]bool)
+	for _, selection := range selections {
+		selected[selection] = true
+	}
+	untouched := make(map[string]bool)
+	for index, photograph := range photos {
+		if !selected[index] {
+			untouched[photograph.Identifier()] = true
+		}
+	}
+
+	/*
+		NOTE: Every version of a photograph shares its identifier, so new numbers are handed out
+		per identifier and not per file. Selecting the master and the print of one photograph
+		gives both the same new number, keeping them tied together.
+	*/
+	plan := newPlan("modify", dir)
+	numbers := make(map[string]int)
+	newIdentifiers := make(map[string]string)
+	var renames []Action
+	for _, selection := range selections {
+		oldIdentifier := photos[selection].Identifier()
+		number := ""
+		if start != "keep" {
+			if _, seen := numbers[oldIdentifier]; !seen {
+				first, _ := strconv.Atoi(start)
+				numbers[oldIdentifier] = first + len(numbers)
+			}
+			number = strconv.Itoa(numbers[oldIdentifier])
+		}
+		photograph := modified(photos[selection], number)
+
+		// Run the new name back through the parser to be sure it is still valid
+		_, err := photo.Parse(photograph.Filename())
+		if err != nil {
+			return errors.Join(errors.New("\""+photograph.Filename()+"\" would not be a valid name"), err)
+		}
+
+		// Refuse to tie a photograph to an identifier another photograph is already using
+		newIdentifier := photograph.Identifier()
+		if newIdentifier != oldIdentifier && untouched[newIdentifier] {
+			return errors.New("identifier " + newIdentifier + " already belongs to another photograph")
+		}
+		if other, ok := newIdentifiers[newIdentifier]; ok && other != oldIdentifier {
+			return errors.New("both " + other + " and " + oldIdentifier + " would become " + newIdentifier)
+		}
+		newIdentifiers[newIdentifier] = oldIdentifier
+
+		// Get the new path for the renamed file by replacing the filename in the old path
+		oldpath := validFiles[selection]
+		newpath := filepath.Join(filepath.Dir(oldpath), photograph.Filename())
+		if filepath.Clean(oldpath) == newpath {
+			continue
+		}
+		renames = append(renames, Action{From: oldpath, To: newpath})
+	}
+
+	// Photographs can trade identifiers with each other, so the renames are ordered all together
+	err = plan.renameAll(renames)
+	if err != nil {
+		return err
+	}
+
+	// Sidecars are renamed along with their photographs
+	err = plan.followSidecars()
+	if err != nil {
+		return err
+	}
+
+	// A dry run only shows the plan, there is nothing to confirm
+	if dryRun {
+		return plan.run(dryRun, jsonPath)
+	}
+
+	// Ask the user for a final confirmation of the changes
+	fmt.Print(plan.table())
+	okay, err := promptConfimation(scanner, "Do these changes look okay?")
+	if err != nil {
+		return err
+	}
+
+	if okay {
+		fmt.Println("Okay!")
+		return plan.run(dryRun, jsonPath)
+	}
+
+	fmt.Println("Aborting!")
+	return nil
+}
+
+// Prompts for a new value of an attribute until a valid one is given, defaulting to "keep" which
+// leaves the attribute as it is. Only an error reading the input is returned
+func promptModification(scanner *bufio.Scanner, prompt string, valid func(string) (bool, error)) (string, error) {
+	return promptChecked(scanner, prompt, "keep", func(input string) (string, error) {
+		input = strings.ToLower(input)
+		if input == "keep" {
+			return "keep", nil
+		}
+
+		ok, err := valid(input)
+		if !ok {
+			return "", err
+		}
+		return input, nil
+	})
+}
+
+// Validates a roll letter in any case that can also be "none"
+func validOptionalLetter(letter string) (bool, error) {
+	if letter == "none" {
+		return true, nil
+	}
+	return photo.ValidLetter(strings.ToUpper(letter))
+}
+
+// Validates a class or subversion that can also be "none"
+func validOptionalWord(word string) (bool, error) {
+	if word == "none" {
+		return true, nil
+	}
+	return photo.ValidWord(word)
+}
+
+// Validates a start number is a positive whole number
+func validStartNumber(number string) (bool, error) {
+	valid, err := regexp.MatchString("^([0-9]*[1-9][0-9]*)$", number)
+	if !valid || err != nil {
+		return false, errors.Join(errors.New("invalid start number. Only use a whole number above 0"), err)
+	}
+	return true, nil
+}
@@ -15,9 +15,27 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+
+	"github.com/karlramberg/loupe/exif"
+	"github.com/karlramberg/loupe/photo"
 )
 
-func name(dir string) error {
+// Values given to name as flags instead of through the prompts. Empty values are prompted for
+type NameOptions struct {
+	selection  string
+	date       string
+	roll       string
+	start      string
+	class      string
+	group      string
+	version    string
+	subversion string
+	yes        bool   // Take the default for anything not given and skip the confirmation
+	batch      string // A CSV or JSON file giving the attributes of each file
+	archive    string // An archive whose identifiers new numbers should continue after
+}
+
+func name(dir string, opts NameOptions, dryRun bool, jsonPath string) error {
 	fmt.Println("Loupe", loupeVersion, "-", "Name")
 
 	// Check that the -w flag was used
@@ -31,6 +49,17 @@ func name(dir string) error {
 		return errors.New("directory \"" + dir + "\" not found")
 	}
 
+	// Setup a scanner to standard input for the user to give values
+	scanner := bufio.NewScanner(os.Stdin)
+
+	// Deal with a change that was cut off before looking at the files it may have moved
+	if !dryRun {
+		okay, err := recoverInterrupted(dir, scanner)
+		if err != nil || !okay {
+			return err
+		}
+	}
+
 	// Get a list of image files in the directory and its subdirectories
 	files, err := getImageFiles(dir)
 	if err != nil {
@@ -42,125 +71,196 @@ func name(dir string) error {
 		return errors.New("no image files found in \"" + dir + "\"")
 	}
 
-	// Print a table of the image files
-	fmt.Println(getFileTable(files))
+	// Check that the archive exists before asking the user anything
+	if opts.archive != "" {
+		stat, err := os.Stat(opts.archive)
+		if os.IsNotExist(err) || !stat.IsDir() {
+			return errors.New("archive \"" + opts.archive + "\" not found")
+		}
+	}
 
-	// Setup a scanner to standard input for the user to give values
-	scanner := bufio.NewScanner(os.Stdin)
+	// A batch file gives every file its own attributes, so there is nothing to select or prompt for
+	if opts.batch != "" {
+		start := opts.start
+		if start == "" {
+			start = "1"
+		}
+		start, err := checkStart(start)
+		if err != nil {
+			return errors.Join(errors.New("invalid -start"), err)
+		}
 
-	// Ask the user for a selection of all or some of the files
-	selections, err := promptSelection(scanner, len(files))
-	for err != nil {
-		fmt.Println("Error:", err)
-		selections, err = promptSelection(scanner, len(files))
+		paths, photographs, err := readBatchNames(dir, files, opts)
+		if err != nil {
+			return err
+		}
+		return runNames(scanner, dir, files, paths, photographs, start, opts, dryRun, jsonPath)
 	}
 
-	// Disable autodating by default if any non-raw files are selected
-	defaultDate := "auto"
-	for _, selection := range selections {
-		extension := strings.ToLower(filepath.Ext(files[selection]))
-		if !slices.Contains(rawExtensions, extension) {
-			defaultDate = "none"
-			break
+	// The automatic date of each file, only read when it is needed
+	autoDates := newAutoDates(files)
+
+	// Ask the user for a selection of all or some of the files
+	var selections []int
+	if input := opts.flagOrDefault(opts.selection, "all"); input != "" {
+		selections, err = parseSelection(input, len(files))
+		if err != nil {
+			return errors.Join(errors.New("invalid -select"), err)
+		}
+	} else {
+		selections, err = selectFiles(scanner, files, previewName(files, opts, autoDates))
+		if err != nil {
+			return err
 		}
 	}
 
-	// Create a template photo to store the values we get from the use
-	var template Photograph
+	// Create a template photograph to store the values we get from the use
+	var template photo.Photograph
 
-	// Ask the user for a date string, format YYYYMMDD
-	template.date, err = promptDate(scanner, defaultDate)
-	for err != nil {
-		fmt.Println("Invalid:", err)
-		template.date, err = promptDate(scanner, defaultDate)
+	// Ask the user for a date string, format YYYYMMDD. Automatic dates are only worked out if the
+	// user asks for them, and only if every selected file can be given one
+	checkAuto := func(date string) (string, error) {
+		date, err := checkDate(date)
+		if err != nil || date != "auto" {
+			return date, err
+		}
+
+		var problems []error
+		for _, selection := range selections {
+			if problem := autoDates.problem(selection); problem != "" {
+				problems = append(problems, errors.New("\""+files[selection]+"\" "+problem))
+			}
+		}
+		if len(problems) > 0 {
+			return "", errors.Join(append([]error{errors.New("not every selected file can be dated automatically, give a date instead")}, problems...)...)
+		}
+		return date, nil
+	}
+	template.Date, err = opts.ask(scanner, "date", opts.date, "Enter date", "auto", checkAuto)
+	if err != nil {
+		return err
+	}
+
+	// Show where each automatic date came from so the user can spot a bad one
+	if template.Date == "auto" {
+		fmt.Println(getDateTable(files, selections, autoDates))
 	}
 
 	// Ask the use for an optional roll letter, "none" is the default value
-	template.letter, template.number, err = promptNumber(scanner)
-	for err != nil {
-		fmt.Println("Invalid:", err)
-		template.letter, template.number, err = promptNumber(scanner)
+	template.Letter, err = opts.ask(scanner, "roll", opts.roll, "Enter roll letter", "none", checkLetter)
+	if err != nil {
+		return err
+	}
+
+	// Ask the user for the number to start counting from
+	start, err := opts.ask(scanner, "start", opts.start, "Enter start number", "1", checkStart)
+	if err != nil {
+		return err
 	}
 
 	// Ask the user for an optional class, "none" is the default value
-	template.class, err = promptWord(scanner, "Enter class", "none")
-	for err != nil {
-		fmt.Println("Invalid:", err)
-		template.class, err = promptWord(scanner, "Enter class", "none")
+	template.Class, err = opts.ask(scanner, "class", opts.class, "Enter class", "none", checkWord)
+	if err != nil {
+		return err
 	}
 
 	// Ask the user for a group, the "default" group is the default value
-	template.group, err = promptWord(scanner, "Enter group", "default")
-	for err != nil {
-		fmt.Println("Invalid:", err)
-		template.group, err = promptWord(scanner, "Enter group", "default")
+	template.Group, err = opts.ask(scanner, "group", opts.group, "Enter group", "default", checkWord)
+	if err != nil {
+		return err
 	}
 
 	// Ask the user for a version, "lolidk" is the default version
-	template.version, err = promptWord(scanner, "Enter version", "lolidk") // TODO
-	for err != nil {
-		fmt.Println("Invalid:", err)
-		template.version, err = promptWord(scanner, "Enter version", "lolidk")
+	template.Version, err = opts.ask(scanner, "version", opts.version, "Enter version", "lolidk", checkWord) // TODO
+	if err != nil {
+		return err
 	}
 
 	// Ask the user for an optional subversion, "none" is the default value
-	template.subversion, err = promptWord(scanner, "Enter subversion", "none")
-	for err != nil {
-		fmt.Println("Invalid:", err)
-		template.subversion, err = promptWord(scanner, "Enter subversion", "none")
+	template.Subversion, err = opts.ask(scanner, "subversion", opts.subversion, "Enter subversion", "none", checkWord)
+	if err != nil {
+		return err
 	}
 
-	// Setup and save the new filenames starting with the values from the template photograph
-	var newFilenames []string
-	dateCounter := make(map[string]int)
-	checklist := ""
-	for _, selection := range selections {
-		photo := template
-
-		/*
-			NOTE: Files are auto dated using their last modification time instead of a
-			creation date. This is because creation dates are inconsistent across systems
-			and auto dating is only useful for digital raw files, which should never be
-			modified after they are made anyways
-		*/
-		if photo.date == "auto" {
-			stats, err := os.Stat(files[selection])
-			if err != nil {
-				fmt.Println("Error: there was as problem getting an auto date for", files[selection])
-				fmt.Println(err)
+	// Give every selected file a copy of the template, filling in its own date if it's automatic
+	paths := make([]string, len(selections))
+	photographs := make([]photo.Photograph, len(selections))
+	for i, selection := range selections {
+		paths[i] = files[selection]
+		photographs[i] = template
+		if template.Date == "auto" {
+			photographs[i].Date, _ = autoDates.get(selection)
+		}
+	}
 
-			}
+	return runNames(scanner, dir, files, paths, photographs, start, opts, dryRun, jsonPath)
+}
 
-			time := stats.ModTime()
-			date := strconv.Itoa(time.Year())
-			date += fmt.Sprintf("%02s", strconv.Itoa(int(time.Month())))
-			date += fmt.Sprintf("%02s", strconv.Itoa(time.Day()))
+// The value to use for a flag that wasn't given. Only -yes has one, otherwise the user is prompted
+func (o NameOptions) flagOrDefault(value, defaultValue string) string {
+	if value == "" && o.yes {
+		return defaultValue
+	}
+	return value
+}
 
-			photo.date = date
+// Gets an attribute from its flag, or its default with -yes. Otherwise the user is prompted until
+// they give a valid value or the input can't be read. Flags are checked the same way as prompted
+// values
+func (o NameOptions) ask(scanner *bufio.Scanner, flagName, flagValue, prompt, defaultValue string, check func(string) (string, error)) (string, error) {
+	if value := o.flagOrDefault(flagValue, defaultValue); value != "" {
+		checked, err := check(value)
+		if err != nil {
+			return "", errors.Join(errors.New("invalid -"+flagName), err)
 		}
+		return checked, nil
+	}
 
-		// Add the number, based on the given start number and the number of times we've seen
-		// photographs with the same date before in this loop
-		number := strconv.Itoa(dateCounter[photo.date] + 1)
-		padding := 3
-		if photo.letter != "none" {
-			padding = 2
+	return promptChecked(scanner, prompt, defaultValue, check)
+}
+
+// Plans renaming each file to its photograph, confirms the plan with the user and runs it
+func runNames(scanner *bufio.Scanner, dir string, files, paths []string, photographs []photo.Photograph, start string, opts NameOptions, dryRun bool, jsonPath string) error {
+	// Find the numbers already taken by the files that aren't being named, and by the archive
+	used, err := findUsedNumbers(files, paths)
+	if err != nil {
+		return err
+	}
+	if opts.archive != "" {
+		archiveFiles, err := getArchivePaths(opts.archive)
+		if err != nil {
+			return err
+		}
+		archiveUsed, err := findUsedNumbers(archiveFiles, nil)
+		if err != nil {
+			return err
 		}
-		photo.number = fmt.Sprintf("%0*s", padding, number)
-		dateCounter[photo.date]++
+		used.add(archiveUsed)
+	}
 
-		// Add the extension from the original filename
-		photo.extension = strings.ToLower(filepath.Ext(files[selection]))
+	plan := newPlan("name", dir)
+	err = plan.nameFiles(paths, photographs, start, used)
+	if err != nil {
+		return err
+	}
 
-		// Covert the photograph struct to it's filename and add it to the list for later
-		// Also add it to a checklist of changes to print directly after the loop
-		filename := photo.filename()
-		newFilenames = append(newFilenames, filename)
-		checklist += "Renaming " + files[selection] + " to " + photo.filename() + "\n"
+	// Sidecars are renamed along with their photographs
+	err = plan.followSidecars()
+	if err != nil {
+		return err
+	}
+
+	// A dry run only shows the plan, there is nothing to confirm
+	if dryRun {
+		return plan.run(dryRun, jsonPath)
+	}
+
+	// Ask the user for a final confirmation of the changes, unless they already gave it with -yes
+	fmt.Print(plan.table())
+	if opts.yes {
+		return plan.run(dryRun, jsonPath)
 	}
 
-	// Ask the user for a final confirmation of the changes
-	fmt.Print(checklist)
 	okay, err := promptConfimation(scanner, "Do these changes look okay?")
 	if err != nil {
 		return err
@@ -168,23 +268,254 @@ func name(dir string) error {
 
 	if okay {
 		fmt.Println("Okay!")
+		return plan.run(dryRun, jsonPath)
+	}
+
+	fmt.Println("Aborting!")
+	return nil
+}
+
+// Plans renaming each file in place to the filename of its photograph. Photographs without a number
+// are numbered from the start number, skipping past the highest number already used on their date
+// and roll so they never collide with an existing identifier
+func (p *Plan) nameFiles(paths []string, photographs []photo.Photograph, start string, used UsedNumbers) error {
+	// Numbers given outright, like the ones in a batch file, are reserved before any are handed
+	// out, so an automatic number can't land on one of them
+	taken := newUsedNumbers()
+	taken.add(used)
+	for _, photograph := range photographs {
+		if number, err := strconv.Atoi(photograph.Number); err == nil {
+			taken.use(photograph, number)
+		}
+	}
 
-		for index, selection := range selections {
-			// Get the new path for the renamed file by replacing the filename in the old path
-			oldpath := files[selection]
-			newpath := filepath.Join(filepath.Dir(oldpath), newFilenames[index])
-
-			// Rename the file!
-			err = os.Rename(oldpath, newpath)
-			if err != nil {
-				return errors.Join(errors.New("there was a problem renaming \""+filepath.Base(oldpath)+"\""), err)
-			} else {
-				fmt.Println("Renamed", filepath.Base(oldpath), "to", filepath.Base(newpath))
+	first, _ := strconv.Atoi(start)
+	next := make(map[string]int)
+	var renames []Action
+	for i, photograph := range photographs {
+		if photograph.Number == "" {
+			key := numberKey(photograph.Date, photograph.Letter)
+			if _, ok := next[key]; !ok {
+				next[key] = first
+				if highest, ok := taken.highest[key]; ok && highest >= first {
+					next[key] = highest + 1
+					fmt.Printf("Numbering %s from %d, %d is already used\n", strings.TrimSuffix(key, "-none"), next[key], highest)
+				}
 			}
+
+			photograph.Number = padNumber(next[key], photograph.Letter)
+			next[key]++
+		} else if used.identifiers[photograph.Identifier()] {
+			return errors.New("\"" + paths[i] + "\" can't be numbered " + photograph.Identifier() + ", that identifier is already used")
 		}
-	} else {
-		fmt.Println("Aborting!")
+
+		// Add the extension from the original filename
+		photograph.Extension = strings.ToLower(filepath.Ext(paths[i]))
+
+		// Run the new name back through the parser, a roll can run out of frames
+		_, err := photo.Parse(photograph.Filename())
+		if err != nil {
+			return errors.Join(errors.New("\""+paths[i]+"\" can't be named \""+photograph.Filename()+"\""), err)
+		}
+
+		// Get the new path for the renamed file by replacing the filename in the old path
+		oldpath := filepath.Clean(paths[i])
+		newpath := filepath.Join(filepath.Dir(oldpath), photograph.Filename())
+		if oldpath == newpath {
+			continue
+		}
+		renames = append(renames, Action{From: oldpath, To: newpath})
 	}
 
-	return nil
+	// Files can trade names with each other, so the renames are ordered all together
+	return p.renameAll(renames)
+}
+
+// Pads a number to 3 digits, or 2 if it's a frame on a lettered roll
+func padNumber(number int, letter string) string {
+	return fmt.Sprintf("%0*d", photo.NumberWidth(letter), number)
+}
+
+// Numbers are counted separately for every date and roll
+func numberKey(date, letter string) string {
+	return date + "-" + letter
+}
+
+// The identifiers already given to photographs, and the highest number used on each date and roll
+type UsedNumbers struct {
+	identifiers map[string]bool
+	highest     map[string]int
+}
+
+func newUsedNumbers() UsedNumbers {
+	return UsedNumbers{identifiers: make(map[string]bool), highest: make(map[string]int)}
+}
+
+// Finds the numbers used by every validly named file, leaving out the files in skip
+func findUsedNumbers(files, skip []string) (UsedNumbers, error) {
+	used := newUsedNumbers()
+	skipped := make(map[string]bool)
+	for _, file := range skip {
+		skipped[file] = true
+	}
+
+	for _, file := range files {
+		if skipped[file] {
+			continue
+		}
+
+		photograph, err := photo.Parse(filepath.Base(file))
+		if err != nil {
+			continue
+		}
+
+		number, err := strconv.Atoi(photograph.Number)
+		if err != nil {
+			return used, errors.Join(errors.New("trouble reading the number of \""+file+"\""), err)
+		}
+
+		used.use(photograph, number)
+	}
+	return used, nil
+}
+
+// Marks the identifier of a photograph as used, along with its number on its date and roll
+func (u UsedNumbers) use(photograph photo.Photograph, number int) {
+	u.identifiers[photograph.Identifier()] = true
+	key := numberKey(photograph.Date, photograph.Letter)
+	if highest, ok := u.highest[key]; !ok || number > highest {
+		u.highest[key] = number
+	}
+}
+
+// Adds the numbers used somewhere else, like an archive
+func (u UsedNumbers) add(other UsedNumbers) {
+	for identifier := range other.identifiers {
+		u.identifiers[identifier] = true
+	}
+	for key, number := range other.highest {
+		if highest, ok := u.highest[key]; !ok || number > highest {
+			u.highest[key] = number
+		}
+	}
+}
+
+// Where an automatic date came from
+const (
+	dateFromMetadata = "metadata"
+	dateFromModTime  = "modification time"
+)
+
+// Finds the date a file was shot, from its metadata if it has any and its modification time if
+// not. A file that can't even be looked at has no automatic date, and "" is returned
+func autoDate(path string) (date, source string) {
+	taken, err := exif.DateTakenFile(path)
+	if err == nil {
+		return taken.Format("20060102"), dateFromMetadata
+	}
+
+	/*
+		NOTE: Files without metadata are auto dated using their last modification time instead of
+		a creation date. This is because creation dates are inconsistent across systems. Digital
+		raw files should never be modified after they are made, so their modification time is
+		trustworthy, but anything else may have been copied or edited since it was shot.
+	*/
+	stats, err := os.Stat(path)
+	if err != nil {
+		return "", ""
+	}
+	return stats.ModTime().Format("20060102"), dateFromModTime
+}
+
+// Says why a file can't be given an automatic date, or "" if it can. Only raw files can be dated
+// by their modification time
+func autoDateProblem(path, date, source string) string {
+	extension := strings.ToLower(filepath.Ext(path))
+	if date == "" {
+		return "has no automatic date"
+	}
+	if source == dateFromModTime && !slices.Contains(rawExtensions, extension) {
+		return "has no date in its metadata and isn't a raw file, so its modification time can't be trusted"
+	}
+	return ""
+}
+
+// The automatic dates of a list of files, each one worked out the first time it is asked for
+type AutoDates struct {
+	files   []string
+	dates   map[int]string
+	sources map[int]string
+}
+
+func newAutoDates(files []string) *AutoDates {
+	return &AutoDates{
+		files:   files,
+		dates:   make(map[int]string),
+		sources: make(map[int]string),
+	}
+}
+
+// The automatic date of a file and where it came from
+func (a *AutoDates) get(i int) (date, source string) {
+	if _, ok := a.sources[i]; !ok {
+		a.dates[i], a.sources[i] = autoDate(a.files[i])
+	}
+	return a.dates[i], a.sources[i]
+}
+
+// Says why a file can't be given an automatic date, or "" if it can
+func (a *AutoDates) problem(i int) string {
+	date, source := a.get(i)
+	return autoDateProblem(a.files[i], date, source)
+}
+
+// Shows the name a file would be given in the selector, from the flags given and the defaults for
+// everything else. Numbers are handed out once every file is selected, so the number is left as #
+func previewName(files []string, opts NameOptions, autoDates *AutoDates) func(int) string {
+	value := func(flagValue, defaultValue string, check func(string) (string, error)) string {
+		if checked, err := check(flagValue); flagValue != "" && err == nil {
+			return checked
+		}
+		return defaultValue
+	}
+
+	template := photo.Photograph{
+		Date:       value(opts.date, "auto", checkDate),
+		Letter:     value(opts.roll, photo.None, checkLetter),
+		Class:      value(opts.class, photo.None, checkWord),
+		Group:      value(opts.group, "default", checkWord),
+		Version:    value(opts.version, "lolidk", checkWord),
+		Subversion: value(opts.subversion, photo.None, checkWord),
+	}
+	template.Number = strings.Repeat("#", photo.NumberWidth(template.Letter))
+
+	return func(i int) string {
+		photograph := template
+		photograph.Extension = strings.ToLower(filepath.Ext(files[i]))
+		if photograph.Date != "auto" {
+			return "New name " + photograph.Filename()
+		}
+
+		date, source := autoDates.get(i)
+		if date == "" {
+			photograph.Date = "YYYYMMDD"
+			return "New name " + photograph.Filename() + ", no automatic date"
+		}
+		photograph.Date = date
+		return "New name " + photograph.Filename() + ", dated from its " + source
+	}
+}
+
+// Constructs a table of the automatic date of each selected file and where it came from
+func getDateTable(files []string, selections []int, autoDates *AutoDates) (table string) {
+	var width int
+	for _, selection := range selections {
+		width = max(width, len(files[selection]))
+	}
+
+	for _, selection := range selections {
+		date, source := autoDates.get(selection)
+		table += fmt.Sprintf(" %-*s  %s  from %s\n", width, files[selection], date, source)
+	}
+	return
 }
@@ -0,0 +1,54 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	name_test.go
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Numbers given in a batch file are reserved first, so the files numbered automatically go after
+// them instead of landing on one
+func TestBatchMixedNumbers(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "20230101-001_granite_master.tif"))
+	writeTestFile(t, filepath.Join(dir, "scan1.tif"))
+	writeTestFile(t, filepath.Join(dir, "scan2.tif"))
+	writeTestFile(t, filepath.Join(dir, "scan3.tif"))
+	writeTestFile(t, filepath.Join(dir, "scan4.tif"))
+
+	batch := filepath.Join(t.TempDir(), "batch.csv")
+	err := os.WriteFile(batch, []byte("file,date,number,group,version\n"+
+		"scan1.tif,20230101,,granite,master\n"+
+		"scan2.tif,20230101,3,granite,master\n"+
+		"scan3.tif,20230101,,granite,master\n"+
+		"scan4.tif,20230102,,granite,master\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := getImageFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := NameOptions{batch: batch, yes: true}
+	paths, photographs, err := readBatchNames(dir, files, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runNames(testScanner(), dir, files, paths, photographs, "1", opts, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectTestFile(t, filepath.Join(dir, "20230101-001_granite_master.tif"), "20230101-001_granite_master.tif")
+	expectTestFile(t, filepath.Join(dir, "20230101-003_granite_master.tif"), "scan2.tif")
+	expectTestFile(t, filepath.Join(dir, "20230101-004_granite_master.tif"), "scan1.tif")
+	expectTestFile(t, filepath.Join(dir, "20230101-005_granite_master.tif"), "scan3.tif")
+	expectTestFile(t, filepath.Join(dir, "20230102-001_granite_master.tif"), "scan4.tif")
+}
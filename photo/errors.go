@@ -0,0 +1,117 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	errors.go
+*/
+
+package photo
+
+import (
+	"errors"
+	"strings"
+)
+
+// Every problem Parse finds wraps one of these, so callers can tell what was wrong with errors.Is
+var (
+	ErrFormat     = errors.New("filename not formatted \"identifier_group_version\"")
+	ErrIdentifier = errors.New("identifier not formatted \"date-number\"")
+	ErrDate       = errors.New("date is incorrect")
+	ErrNumber     = errors.New("number should only use capital letters and numbers")
+	ErrClass      = errors.New("class not formatted correctly")
+	ErrGroup      = errors.New("group not formatted correctly")
+	ErrVersion    = errors.New("version not formatted correctly")
+	ErrSubversion = errors.New("subversion not formatted correctly")
+)
+
+// One problem with a filename, pointing at exactly which part of the name is wrong
+type ParseError struct {
+	Field  string // format, identifier, date, number, class, group, version or subversion
+	Value  string // The part of the filename that is wrong
+	Start  int    // Byte offset of Value in the filename
+	End    int    // Byte offset just past Value
+	Reason string // What is wrong with it, if there is more to say than Err
+	Fix    string // A suggestion for how to fix it
+	Err    error  // One of the Err variables above
+}
+
+func (e *ParseError) Error() string {
+	if e.Reason == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ", " + e.Reason
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Every problem Parse found with a filename, in the order they appear in the name
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, problem := range e {
+		messages[i] = problem.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (e ParseErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, problem := range e {
+		errs[i] = problem
+	}
+	return errs
+}
+
+// A piece of a filename and the byte offset it starts at
+type span struct {
+	text  string
+	start int
+}
+
+func (s span) end() int {
+	return s.start + len(s.text)
+}
+
+// Splits a piece of a filename at every separator, keeping track of where each part starts
+func (s span) split(sep string) (spans []span) {
+	start := s.start
+	for _, text := range strings.Split(s.text, sep) {
+		spans = append(spans, span{text: text, start: start})
+		start += len(text) + len(sep)
+	}
+	return
+}
+
+// Builds a ParseError for a piece of the filename
+func problem(field string, s span, err error, reason, fix string) *ParseError {
+	return &ParseError{
+		Field:  field,
+		Value:  s.text,
+		Start:  s.start,
+		End:    s.end(),
+		Reason: reason,
+		Fix:    fix,
+		Err:    err,
+	}
+}
+
+// Suggests a fix for a class, group, version or subversion that isn't a lowercase alphanumeric word
+func wordFix(word string) string {
+	if word == "" {
+		return "fill in the empty part or remove the extra hyphen"
+	}
+
+	cleaned := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return -1
+	}, strings.ToLower(word))
+
+	if cleaned == "" {
+		return "only use lowercase letters and digits"
+	}
+	return "try \"" + cleaned + "\""
+}
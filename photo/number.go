@@ -0,0 +1,73 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	number.go
+*/
+
+package photo
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+	NOTE: Identifiers have to sort by plain string comparison, since that is all a file browser
+	or ls can do. Numbers are 3 digits and frames on a lettered roll are 2, which sorts fine until
+	a day has more than 999 photographs or a bulk loaded roll runs past frame 99. Writing 100 as
+	is would put it between 10 and 11, so a number too big for its width is written after a run
+	of nines as wide as the width: frame 123 of roll B is B99123, which sorts after B99. This
+	allows one more digit, so frames go up to 999 and numbers up to 9999.
+
+	Rolls work the same way. After Z comes ZA through ZZ, then ZZA and so on, with a Z in front
+	for every time the letters ran out. A digit sorts before any letter, so Z36 comes before ZA01.
+*/
+
+// How many digits a number has, 2 for a frame on a lettered roll and 3 otherwise
+func NumberWidth(letter string) int {
+	if letter == None || letter == "" {
+		return 3
+	}
+	return 2
+}
+
+// Writes a number the way it goes in an identifier
+func writeNumber(number, letter string) string {
+	width := NumberWidth(letter)
+	if len(number) > width {
+		return strings.Repeat("9", width) + number
+	}
+	return number
+}
+
+// Reads a number back out of an identifier. If it isn't written the right way, the reason and a
+// fix are returned instead
+func readNumber(written, letter string) (number, reason, fix string) {
+	width := NumberWidth(letter)
+	nines := strings.Repeat("9", width)
+	if len(written) == width {
+		return written, "", ""
+	}
+	if len(written) == 2*width+1 && strings.HasPrefix(written, nines) && written[width] != '0' {
+		return written[width:], "", ""
+	}
+
+	reason = fmt.Sprintf("numbers are %d digits, or %s and %d digits past %s", width, nines, width+1, nines)
+	if width == 2 {
+		reason = "frames on a lettered roll are 2 digits, or 99 and 3 digits past frame 99"
+	}
+
+	// Suggest the number written the right way, if it isn't too big to be written at all
+	value := written
+	if len(written) > 2*width && strings.HasPrefix(written, nines) {
+		value = written[width:]
+	}
+	value = strings.TrimLeft(value, "0")
+	if len(value) > width+1 {
+		return "", reason, "use a new date or roll, there are only so many numbers"
+	}
+	if letter == None {
+		letter = ""
+	}
+	return "", reason, "try \"" + letter + writeNumber(fmt.Sprintf("%0*s", width, value), letter) + "\""
+}
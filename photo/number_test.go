@@ -0,0 +1,118 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	number_test.go
+*/
+
+package photo
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"testing"
+)
+
+// Every number a photograph can have is written, parsed back the same and its identifier sorts in
+// order
+func TestNumberRoundTrip(t *testing.T) {
+	tests := []struct {
+		letter string
+		last   int
+	}{
+		{None, 9999},
+		{"B", 999},
+		{"ZA", 999},
+	}
+
+	for _, test := range tests {
+		width := NumberWidth(test.letter)
+		var identifiers []string
+		for n := 1; n <= test.last; n++ {
+			p := Photograph{"20230615", test.letter, fmt.Sprintf("%0*d", width, n), None, "granite", "master", None, ".tif"}
+			name := p.Filename()
+			got, err := Parse(name)
+			if err != nil {
+				t.Errorf("%d on roll %s: Parse(%q) returned %v", n, test.letter, name, err)
+				continue
+			}
+			if got != p {
+				t.Errorf("%d on roll %s: Parse(%q) = %+v, expected %+v", n, test.letter, name, got, p)
+			}
+			identifiers = append(identifiers, p.Identifier())
+		}
+
+		sorted := slices.Clone(identifiers)
+		sort.Strings(sorted)
+		for i := range identifiers {
+			if identifiers[i] != sorted[i] {
+				t.Errorf("roll %s sorts %q where %q should be", test.letter, sorted[i], identifiers[i])
+				break
+			}
+		}
+	}
+}
+
+// Identifiers sort by plain string comparison in the order they were shot
+func TestIdentifierOrder(t *testing.T) {
+	want := []string{
+		"20230615-001", "20230615-999", "20230615-9991000", "20230615-9999999",
+		"20230615-B01", "20230615-B99", "20230615-B99100", "20230615-B99999",
+		"20230615-Z36", "20230615-ZA01", "20230615-ZA99100", "20230615-ZZ36", "20230615-ZZA01",
+	}
+
+	got := slices.Clone(want)
+	slices.Reverse(got)
+	sort.Strings(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("sorted as %v, expected %v", got, want)
+	}
+}
+
+func TestReadNumber(t *testing.T) {
+	tests := []struct {
+		written string
+		letter  string
+		want    string
+	}{
+		{"007", None, "007"},
+		{"9991000", None, "1000"},
+		{"B07", "B", "07"},
+		{"B99100", "B", "100"},
+	}
+	for _, test := range tests {
+		written := test.written
+		if test.letter != None {
+			written = written[len(test.letter):]
+		}
+		number, reason, _ := readNumber(written, test.letter)
+		if number != test.want || reason != "" {
+			t.Errorf("readNumber(%q, %q) = %q, %q, expected %q", written, test.letter, number, reason, test.want)
+		}
+	}
+
+	// Numbers that fit the width are never written past the nines, and neither is a leading zero
+	rejected := []struct {
+		written string
+		letter  string
+	}{
+		{"99099", "B"},
+		{"99905", None},
+		{"9990999", None},
+		{"0007", None},
+		{"7", "B"},
+	}
+	for _, test := range rejected {
+		number, reason, fix := readNumber(test.written, test.letter)
+		if number != "" || reason == "" || fix == "" {
+			t.Errorf("readNumber(%q, %q) = %q, %q, %q, expected it to be refused with a fix", test.written, test.letter, number, reason, fix)
+		}
+	}
+
+	for _, name := range []string{"20230615-B99099_granite_master.tif", "20230615-99905_granite_master.tif"} {
+		if _, err := Parse(name); !errors.Is(err, ErrNumber) {
+			t.Errorf("Parse(%q) returned %v, expected ErrNumber", name, err)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	photo.go
+*/
+
+// Package photo is Loupe's naming model. It parses filenames into photographs, builds filenames
+// and folders back out of them, and validates each piece. It never touches the disk, so other
+// programs can import it to read and write Loupe filenames the same way the loupe command does.
+package photo
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Optional attributes (letter, class and subversion) hold None when a photograph doesn't have them
+const None = "none"
+
+// A number is an optional roll letter followed by the number, or the frame if there is a letter
+var numberPattern = regexp.MustCompile("^([A-Z]*)([0-9]+)$")
+
+// Classes, groups, versions and subversions are lowercase alphanumeric words
+var wordPattern = regexp.MustCompile("^([a-z0-9]+)$")
+
+// A roll letter is one capital letter, with a Z in front for every time the rolls ran past Z
+var letterPattern = regexp.MustCompile("^(Z*[A-Z])$")
+
+type Photograph struct {
+	Date       string
+	Letter     string
+	Number     string
+	Class      string
+	Group      string
+	Version    string
+	Subversion string
+	Extension  string
+}
+
+// Creates a new photograph given the filename, validating each piece of data. Instead of stopping
+// at the first problem, Parse keeps going and returns every problem it finds as ParseErrors, so a
+// badly named file can be fixed in one go. Parts of the name that are too mangled to find (like
+// the group in a name with four underscores) are skipped rather than guessed at.
+func Parse(name string) (Photograph, error) {
+	var p Photograph
+	var problems ParseErrors
+
+	// Snag the file extension off the end
+	p.Extension = filepath.Ext(name)
+	stem := span{text: strings.TrimSuffix(name, p.Extension)}
+
+	// Split the name into it's main sections at the underscores
+	sections := stem.split("_")
+	if len(sections) != 3 {
+		problems = append(problems, problem("format", stem, ErrFormat,
+			fmt.Sprintf("found %d part(s) instead of 3", len(sections)),
+			"separate the identifier, group and version with one underscore each"))
+	}
+
+	// Split the identifier into it's two parts
+	identifier := sections[0].split("-")
+	if len(identifier) != 2 {
+		problems = append(problems, problem("identifier", sections[0], ErrIdentifier, "",
+			"put one hyphen between the date and the number, like 20241201-007"))
+	} else {
+		// Validate the identifier's date
+		validDate, err := ValidDate(identifier[0].text)
+		if !validDate {
+			problems = append(problems, problem("date", identifier[0], ErrDate, err.Error(),
+				"use a real date formatted YYYYMMDD"))
+		}
+		p.Date = identifier[0].text
+
+		// Validate the identifier's roll letter and number, which is the frame on a lettered roll
+		parts := numberPattern.FindStringSubmatch(identifier[1].text)
+		if parts == nil {
+			fix := "use a number like 007, or a roll letter and frame like B07"
+			if numberPattern.MatchString(strings.ToUpper(identifier[1].text)) {
+				fix = "try \"" + strings.ToUpper(identifier[1].text) + "\""
+			}
+			problems = append(problems, problem("number", identifier[1], ErrNumber, "", fix))
+		} else {
+			p.Letter = parts[1]
+			if p.Letter == "" {
+				p.Letter = None
+			} else if valid, err := ValidLetter(p.Letter); !valid {
+				problems = append(problems, problem("number", identifier[1], ErrNumber, err.Error(),
+					"the roll after Z is ZA, after ZZ is ZZA"))
+			}
+
+			number, reason, fix := readNumber(parts[2], p.Letter)
+			if reason != "" {
+				problems = append(problems, problem("number", identifier[1], ErrNumber, reason, fix))
+			}
+			p.Number = number
+		}
+	}
+
+	// Without exactly three sections there is no telling which one is the group or the version
+	if len(sections) == 3 {
+		// Split the group section into it's one or more parts
+		groups := sections[1].split("-")
+		if len(groups) > 2 {
+			problems = append(problems, problem("group", sections[1], ErrGroup,
+				"use format \"class-group\" or just \"group\"", "use at most one hyphen"))
+		} else if len(groups) == 2 { // Class and group
+			problems = append(problems, checkWord("class", groups[0], ErrClass)...)
+			problems = append(problems, checkWord("group", groups[1], ErrGroup)...)
+			p.Class = groups[0].text
+			p.Group = groups[1].text
+		} else { // Just group
+			problems = append(problems, checkWord("group", groups[0], ErrGroup)...)
+			p.Class = None
+			p.Group = groups[0].text
+		}
+
+		// Split the version section into it's one or more parts
+		versions := sections[2].split("-")
+		if len(versions) > 2 {
+			problems = append(problems, problem("version", sections[2], ErrVersion,
+				"use format \"version-subversion\" or just \"version\"", "use at most one hyphen"))
+		} else if len(versions) == 2 { // Version and subversion
+			problems = append(problems, checkWord("version", versions[0], ErrVersion)...)
+			problems = append(problems, checkWord("subversion", versions[1], ErrSubversion)...)
+			p.Version = versions[0].text
+			p.Subversion = versions[1].text
+		} else { // Just version
+			problems = append(problems, checkWord("version", versions[0], ErrVersion)...)
+			p.Version = versions[0].text
+			p.Subversion = None
+		}
+	}
+
+	if len(problems) > 0 {
+		return Photograph{}, problems
+	}
+	return p, nil
+}
+
+// Checks a class, group, version or subversion, returning a problem if it isn't valid
+func checkWord(field string, s span, err error) ParseErrors {
+	valid, reason := ValidWord(s.text)
+	if valid {
+		return nil
+	}
+	return ParseErrors{problem(field, s, err, reason.Error(), wordFix(s.text))}
+}
+
+// Construct the photograph's filename from its data
+func (p Photograph) Filename() (name string) {
+	// Identifier
+	name += p.Identifier() + "_"
+
+	// Group(s)
+	if p.Class != None {
+		name += p.Class + "-"
+	}
+	name += p.Group + "_"
+
+	// Version(s)
+	name += p.Version
+	if p.Subversion != None {
+		name += "-" + p.Subversion
+	}
+
+	// Extension
+	name += p.Extension
+
+	return
+}
+
+// Construct the directory the photograph should live in based on its group(s) and version(s)
+func (p Photograph) Directory() (dir string) {
+	if p.Class != None {
+		dir = filepath.Join(dir, p.Class+"s")
+	}
+
+	dir = filepath.Join(dir, p.Group)
+	dir = filepath.Join(dir, p.Version+"s")
+
+	if p.Subversion != None {
+		dir = filepath.Join(dir, p.Subversion)
+	}
+
+	return
+}
+
+// Construct the identifier that ties every version of the photograph together
+func (p Photograph) Identifier() (i string) {
+	i += p.Date + "-"
+	if p.Letter != None {
+		i += p.Letter
+	}
+	i += writeNumber(p.Number, p.Letter)
+	return
+}
+
+func (p Photograph) ClassDir() string {
+	if p.Class == None {
+		return ""
+	}
+	return p.Class + "s"
+}
+
+func (p Photograph) GroupDir() string {
+	return filepath.Join(p.ClassDir(), p.Group)
+}
+
+func (p Photograph) VersionDir() string {
+	return filepath.Join(p.GroupDir(), p.Version+"s")
+}
+
+func (p Photograph) SubversionDir() string {
+	if p.Subversion == None {
+		return ""
+	}
+	return filepath.Join(p.VersionDir(), p.Subversion)
+}
+
+var monthLen = []int{31, 28, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
+
+// Validates a given string is a proper date in YYYYMMDD format
+func ValidDate(date string) (bool, error) {
+	if len(date) != 8 {
+		return false, errors.New("use format YYYYMMDD")
+	}
+
+	// Check that all three parts are two digit integers
+	year, err1 := strconv.Atoi(date[0:4])
+	month, err2 := strconv.Atoi(date[4:6])
+	day, err3 := strconv.Atoi(date[6:8])
+	err := errors.Join(err1, err2, err3)
+	if err != nil {
+		return false, errors.New("only use digits")
+	}
+
+	// Check if the month is valid
+	if month < 1 || month > 12 {
+		return false, errors.New("month should be between 01 and 12")
+	}
+
+	// Check if the day is valid given the month
+	if month != 2 {
+		if day < 1 || day > monthLen[month-1] {
+			return false, errors.New("check how many days are in the month")
+		}
+	} else { // Leap year fancy math
+		leapYear := (year%4 == 0) && (!(year%100 == 0) || (year%400 == 0))
+		if (day > 28 && !leapYear) || day > 29 {
+			return false, errors.New("leap years are confusing")
+		}
+	}
+
+	return true, nil
+}
+
+// Validates a given string is a lowercase alphanumeric word
+func ValidWord(word string) (bool, error) {
+	if !wordPattern.MatchString(word) {
+		return false, errors.New("only use alphanumeric characters")
+	}
+	return true, nil
+}
+
+// Validates a given string is a roll letter, one capital letter with a Z in front of it for every
+// time the rolls ran past Z
+func ValidLetter(letter string) (bool, error) {
+	if !letterPattern.MatchString(letter) {
+		return false, errors.New("invalid roll letter. Use one capital letter, after Z comes ZA to ZZ")
+	}
+	return true, nil
+}
+
+// Validates a given string is an identifier, like 20241201-007 or 20270630-B28. Parse already knows
+// every rule for one, so it is given the simplest filename around the identifier
+func ValidIdentifier(identifier string) (bool, error) {
+	p, err := Parse(identifier + "_a_a")
+	if err != nil {
+		return false, err
+	}
+	if p.Identifier() != identifier {
+		return false, ErrIdentifier
+	}
+	return true, nil
+}
+
+// Validates that given string is a proper grouping type
+func ValidType(input string) (bool, error) {
+	valid := (input == "class" || input == "group" || input == "version" || input == "subversion")
+	if !valid {
+		return false, errors.New("invalid type. Use class, group, version or subversion")
+	}
+	return true, nil
+}
@@ -0,0 +1,136 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	photo_test.go
+*/
+
+package photo
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		want Photograph
+	}{
+		{"20241201-007_granite_master.tif", Photograph{"20241201", None, "007", None, "granite", "master", None, ".tif"}},
+		{"20270630-B28_trip-berlin2023_print-8x10.tif", Photograph{"20270630", "B", "28", "trip", "berlin2023", "print", "8x10", ".tif"}},
+		{"20240229-120_granite_master.CR2", Photograph{"20240229", None, "120", None, "granite", "master", None, ".CR2"}},
+		{"20241201-007_granite_master", Photograph{"20241201", None, "007", None, "granite", "master", None, ""}},
+		{"20241201-ZA01_granite_master-web.jpg", Photograph{"20241201", "ZA", "01", None, "granite", "master", "web", ".jpg"}},
+	}
+
+	for _, test := range tests {
+		got, err := Parse(test.name)
+		if err != nil {
+			t.Errorf("Parse(%q) returned %v", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Parse(%q) = %+v, expected %+v", test.name, got, test.want)
+		}
+		if got.Filename() != test.name {
+			t.Errorf("Parse(%q).Filename() = %q", test.name, got.Filename())
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	// Each problem expected in a name, in the order Parse should report them
+	type want struct {
+		field string
+		value string
+		start int
+		end   int
+		err   error
+	}
+
+	tests := []struct {
+		name     string
+		problems []want
+	}{
+		{"20241301-007_Granite_master.tif", []want{
+			{"date", "20241301", 0, 8, ErrDate},
+			{"group", "Granite", 13, 20, ErrGroup},
+		}},
+		{"2024120-07_granite.tif", []want{
+			{"format", "2024120-07_granite", 0, 18, ErrFormat},
+			{"date", "2024120", 0, 7, ErrDate},
+			{"number", "07", 8, 10, ErrNumber},
+		}},
+		{"20241201-007_a-b-c_Master-x.jpg", []want{
+			{"group", "a-b-c", 13, 18, ErrGroup},
+			{"version", "Master", 19, 25, ErrVersion},
+		}},
+		{"20241201007_granite_master.tif", []want{
+			{"identifier", "20241201007", 0, 11, ErrIdentifier},
+		}},
+		{"20241201-b07_granite_master.tif", []want{
+			{"number", "b07", 9, 12, ErrNumber},
+		}},
+		{"20241201-007_-granite_master-.tif", []want{
+			{"class", "", 13, 13, ErrClass},
+			{"subversion", "", 29, 29, ErrSubversion},
+		}},
+		{"20241201-B7_granite_master.tif", []want{
+			{"number", "B7", 9, 11, ErrNumber},
+		}},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.name)
+		var problems ParseErrors
+		if !errors.As(err, &problems) {
+			t.Errorf("Parse(%q) returned %v, expected ParseErrors", test.name, err)
+			continue
+		}
+
+		if len(problems) != len(test.problems) {
+			t.Errorf("Parse(%q) found %d problem(s), expected %d:\n%v", test.name, len(problems), len(test.problems), err)
+			continue
+		}
+
+		for i, w := range test.problems {
+			p := problems[i]
+			if p.Field != w.field || p.Value != w.value || p.Start != w.start || p.End != w.end {
+				t.Errorf("Parse(%q) problem %d = %s %q [%d:%d], expected %s %q [%d:%d]",
+					test.name, i, p.Field, p.Value, p.Start, p.End, w.field, w.value, w.start, w.end)
+			}
+			if test.name[p.Start:p.End] != p.Value {
+				t.Errorf("Parse(%q) problem %d points at %q, not %q", test.name, i, test.name[p.Start:p.End], p.Value)
+			}
+			if !errors.Is(err, w.err) {
+				t.Errorf("Parse(%q) doesn't wrap %v", test.name, w.err)
+			}
+			if p.Fix == "" {
+				t.Errorf("Parse(%q) problem %d has no fix", test.name, i)
+			}
+		}
+	}
+}
+
+func TestParseNumberFix(t *testing.T) {
+	tests := []struct {
+		name string
+		fix  string
+	}{
+		{"20241201-b07_granite_master.tif", "try \"B07\""},
+		{"20241201-07_granite_master.tif", "try \"007\""},
+		{"20241201-B7_granite_master.tif", "try \"B07\""},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.name)
+		var problems ParseErrors
+		if !errors.As(err, &problems) || len(problems) != 1 {
+			t.Errorf("Parse(%q) returned %v, expected one problem", test.name, err)
+			continue
+		}
+		if problems[0].Fix != test.fix {
+			t.Errorf("Parse(%q) suggests %q, expected %q", test.name, problems[0].Fix, test.fix)
+		}
+	}
+}
@@ -0,0 +1,591 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	plan.go
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The kinds of changes a command can make on disk
+const (
+	actionMkdir  = "mkdir"
+	actionMove   = "move"
+	actionRename = "rename"
+	actionRmdir  = "rmdir"
+	actionCopy   = "copy"
+	actionDelete = "delete"
+)
+
+// A single change on disk. Moves, renames and copies use From and To, mkdir and rmdir use Path.
+// Deletes use Path for the file to delete and From for an identical copy that is kept, which is
+// checked before anything is deleted and is what undo restores the file from
+type Action struct {
+	Op   string `json:"op,omitempty"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+// A plan is the full list of changes a command wants to make, built before anything is touched.
+// This lets a command be run with -dry-run to review what it would do, and it means the plan
+// can keep track of files it has already claimed or vacated while it is being built.
+type Plan struct {
+	Command string   `json:"command"`
+	Dir     string   `json:"dir"`
+	Actions []Action `json:"actions"`
+
+	created  map[string]bool // Directories the plan will create
+	claimed  map[string]bool // Paths the plan will move files to
+	vacated  map[string]bool // Paths the plan will move files away from
+	occupied map[string]bool // Directories that will hold a file once the plan is done
+	removed  map[string]bool // Directories the plan will remove
+	known    map[string]bool // Every image file a scan found, if the command scanned the directory
+	dirs     map[string]bool // Whether each directory checked so far exists
+	undoes   string          // The journal run this plan reverses, if it is an undo
+	resumes  string          // The interrupted journal run this plan carries on with, if any
+	done     map[int]bool    // Steps of an interrupted run that already happened
+	pending  []Action        // Actions of an interrupted run that happened but aren't in the manifest
+}
+
+func newPlan(command, dir string) *Plan {
+	return &Plan{
+		Command:  command,
+		Dir:      dir,
+		Actions:  []Action{},
+		created:  make(map[string]bool),
+		claimed:  make(map[string]bool),
+		vacated:  make(map[string]bool),
+		occupied: make(map[string]bool),
+		removed:  make(map[string]bool),
+		dirs:     make(map[string]bool),
+		done:     make(map[int]bool),
+	}
+}
+
+// Works out where a file will be once the plan is done, following every move and rename of it
+func (p *Plan) destination(path string) string {
+	path = filepath.Clean(path)
+	for _, a := range p.Actions {
+		if (a.Op == actionMove || a.Op == actionRename) && a.From == path {
+			path = a.To
+		}
+	}
+	return path
+}
+
+// True if the plan deletes a file
+func (p *Plan) deletes(path string) bool {
+	path = filepath.Clean(path)
+	for _, a := range p.Actions {
+		if a.Op == actionDelete && a.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Plans the creation of a directory, unless it already exists or is already planned
+func (p *Plan) mkdir(path string) {
+	path = filepath.Clean(path)
+	if p.created[path] {
+		return
+	}
+	if _, ok := p.dirs[path]; !ok {
+		p.dirs[path] = exists(path)
+	}
+	if p.dirs[path] {
+		return
+	}
+	p.created[path] = true
+	p.Actions = append(p.Actions, Action{Op: actionMkdir, Path: path})
+}
+
+// Plans moving a file to another directory, possibly under a new name
+func (p *Plan) move(from, to string) {
+	p.transfer(actionMove, from, to)
+}
+
+// Plans renaming a file in place
+func (p *Plan) rename(from, to string) {
+	p.transfer(actionRename, from, to)
+}
+
+/*
+	NOTE: Renaming a set of files at once can ask for a file to take a name another file in the
+	set is giving up, like 002 becoming 003 while 003 becomes 004, or two files trading names. The
+	renames are put in an order where every name is free by the time it is taken, and a loop of
+	names is broken by moving one file aside to a temporary name first, so execute never finds
+	a file in the way.
+*/
+
+// Prefix of the temporary names used to break a loop of renames
+const swapPrefix = "loupe-swap-"
+
+// Plans renaming every file from its old path to its new path, in an order that never overwrites
+// a file that is still waiting to be renamed
+func (p *Plan) renameAll(renames []Action) error {
+	pending := make(map[string]bool)
+	targets := make(map[string]string)
+	for _, r := range renames {
+		from, to := filepath.Clean(r.From), filepath.Clean(r.To)
+		pending[from] = true
+		if other, ok := targets[to]; ok {
+			return errors.New("both \"" + other + "\" and \"" + from + "\" would be named \"" + to + "\"")
+		}
+		targets[to] = from
+	}
+
+	// A name only counts as taken if the file holding it isn't being renamed too
+	for _, r := range renames {
+		to := filepath.Clean(r.To)
+		if !pending[to] && p.taken(to) {
+			return errors.New("\"" + r.From + "\" can't be named \"" + to + "\", something is already there")
+		}
+	}
+
+	queue := slices.Clone(renames)
+	for len(queue) > 0 {
+		var waiting []Action
+		for _, r := range queue {
+			if pending[filepath.Clean(r.To)] {
+				waiting = append(waiting, r)
+				continue
+			}
+			p.rename(r.From, r.To)
+			delete(pending, filepath.Clean(r.From))
+		}
+
+		// Everything left is waiting on another, so they form loops. Move one file aside to break one
+		if len(waiting) == len(queue) {
+			r := waiting[0]
+			temporary := p.temporaryName(r.From)
+			p.rename(r.From, temporary)
+			delete(pending, filepath.Clean(r.From))
+			waiting[0].From = temporary
+		}
+		queue = waiting
+	}
+	return nil
+}
+
+// A free name in the same folder to park a file under while it trades names with another
+func (p *Plan) temporaryName(path string) string {
+	dir, name := filepath.Dir(path), filepath.Base(path)
+	temporary := filepath.Join(dir, swapPrefix+name)
+	for i := 2; p.taken(temporary); i++ {
+		temporary = filepath.Join(dir, swapPrefix+strconv.Itoa(i)+"-"+name)
+	}
+	return temporary
+}
+
+func (p *Plan) transfer(op, from, to string) {
+	from, to = filepath.Clean(from), filepath.Clean(to)
+	p.Actions = append(p.Actions, Action{Op: op, From: from, To: to})
+	p.vacated[from] = true
+	p.claim(to)
+}
+
+// Plans copying a file, leaving the original where it is. The copy is checked against the
+// original before it is put in place
+func (p *Plan) copy(from, to string) {
+	from, to = filepath.Clean(from), filepath.Clean(to)
+	p.Actions = append(p.Actions, Action{Op: actionCopy, From: from, To: to})
+	p.claim(to)
+}
+
+// Plans deleting a file that has an identical copy at backup, which is checked right before
+// the file is deleted
+func (p *Plan) delete(path, backup string) {
+	path, backup = filepath.Clean(path), filepath.Clean(backup)
+	p.Actions = append(p.Actions, Action{Op: actionDelete, From: backup, Path: path})
+	p.vacated[path] = true
+}
+
+// Marks a path as taken, and every directory above it as holding a file
+func (p *Plan) claim(to string) {
+	p.claimed[to] = true
+	for dir := filepath.Dir(to); !p.occupied[dir]; dir = filepath.Dir(dir) {
+		p.occupied[dir] = true
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+}
+
+// Plans the removal of an empty directory
+func (p *Plan) rmdir(path string) {
+	path = filepath.Clean(path)
+	p.removed[path] = true
+	p.Actions = append(p.Actions, Action{Op: actionRmdir, Path: path})
+}
+
+// True if something will be at the path once the plan is done, either already on disk or moved there
+func (p *Plan) taken(path string) bool {
+	path = filepath.Clean(path)
+	return p.claimed[path] || (p.onDisk(path) && !p.vacated[path])
+}
+
+// True if a file is on disk right now. Image files are looked up in what the scan found, if there
+// was one, so planning for a large archive doesn't mean asking the disk about every file twice
+func (p *Plan) onDisk(path string) bool {
+	if p.known != nil && isImage(path) {
+		return p.known[path]
+	}
+	return exists(path)
+}
+
+// True if the directory will be empty once the plan is done
+func (p *Plan) empty(dir string) bool {
+	dir = filepath.Clean(dir)
+	if p.occupied[dir] {
+		return false
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() && p.removed[path] {
+			continue
+		}
+		if !entry.IsDir() && p.vacated[path] && !p.claimed[path] {
+			continue
+		}
+		return false
+	}
+
+	return true
+}
+
+// Traverses a directory, planning the removal of any subdirectory that will be empty once the rest
+// of the plan is done. This looks at what the disk will look like instead of what it is now
+func (p *Plan) cleanEmptyDirs(dir string) (bool, error) {
+	dir = filepath.Clean(dir)
+
+	// Get a list of contents in the directory
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, errors.Join(errors.New("trouble while reading \""+dir+"\""), err)
+	}
+
+	// Go through the entries, recursing on any other directory and skipping files that will be moved
+	remaining := len(entries)
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			removed, err := p.cleanEmptyDirs(path)
+			if err != nil {
+				return false, err
+			}
+			if removed {
+				remaining--
+			}
+		} else if p.vacated[path] && !p.claimed[path] {
+			remaining--
+		}
+	}
+
+	// If the directory will be empty and nothing is being moved into it
+	if remaining <= 0 && !p.occupied[dir] {
+		p.rmdir(dir)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// Constructs a readable table of every action in the plan
+func (p *Plan) table() (table string) {
+	if len(p.Actions) == 0 {
+		return "Nothing to do\n"
+	}
+
+	counts := make(map[string]int)
+	for _, a := range p.Actions {
+		switch a.Op {
+		case actionMkdir, actionRmdir, actionDelete:
+			table += fmt.Sprintf(" %-7s %s\n", a.Op, a.Path)
+		default:
+			table += fmt.Sprintf(" %-7s %s -> %s\n", a.Op, a.From, a.To)
+		}
+		counts[a.Op]++
+	}
+
+	summary := []string{}
+	for _, op := range []string{actionMkdir, actionCopy, actionMove, actionRename, actionDelete, actionRmdir} {
+		if counts[op] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[op], op))
+		}
+	}
+	table += "Planned: " + strings.Join(summary, ", ") + "\n"
+
+	return
+}
+
+// Writes the plan to a file as JSON, so it can be read by other tools or reviewed later
+func (p *Plan) writeJSON(path string) error {
+	data, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return errors.Join(errors.New("trouble while encoding the plan"), err)
+	}
+
+	err = os.WriteFile(path, append(data, '\n'), 0644)
+	if err != nil {
+		return errors.Join(errors.New("trouble while writing the plan to \""+path+"\""), err)
+	}
+
+	fmt.Println("Wrote plan to", path)
+	return nil
+}
+
+// Saves and prints the plan on a dry run, otherwise carries it out
+func (p *Plan) run(dryRun bool, jsonPath string) error {
+	if jsonPath != "" {
+		err := p.writeJSON(jsonPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	if dryRun {
+		fmt.Print(p.table())
+		fmt.Println("Dry run, nothing was changed")
+		return nil
+	}
+
+	return p.execute()
+}
+
+// Carries out every action in the plan in order, stopping at the first failure. The whole plan is
+// written to the directory's journal first, then every action is marked as done as soon as it
+// has happened, so it can be resumed if interrupted or undone later
+func (p *Plan) execute() (err error) {
+	if len(p.Actions) == 0 {
+		return nil
+	}
+
+	journal, err := openJournal(p.Dir, p.Command, p.undoes, p.resumes)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, journal.close())
+	}()
+
+	// A resumed plan was already written down when it was first run
+	resuming := p.resumes != ""
+
+	// The checksums of copied files, so the manifest doesn't have to read them again
+	sums := make(map[string]string)
+
+	// Every action that happened but isn't in the manifest yet. If the plan fails partway, the
+	// manifest still catches up with what did happen before giving up
+	applied := p.pending
+	defer func() {
+		if len(applied) > 0 {
+			err = errors.Join(err, p.syncManifest(journal, applied, sums))
+		}
+	}()
+	if !resuming {
+		for step, a := range p.Actions {
+			err := journal.record(step, statePlanned, a)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for step, a := range p.Actions {
+		if p.done[step] {
+			continue
+		}
+
+		switch a.Op {
+		case actionMkdir:
+			// Note every folder that doesn't exist yet, so undo can remove all of them
+			var missing []string
+			for dir := a.Path; !exists(dir); dir = filepath.Dir(dir) {
+				missing = append([]string{dir}, missing...)
+			}
+
+			err := os.MkdirAll(a.Path, 0755)
+			if err != nil {
+				return errors.Join(errors.New("trouble while creating directory \""+a.Path+"\""), err)
+			}
+			if len(missing) > 0 {
+				fmt.Println("Created folder", a.Path)
+			}
+
+			for _, dir := range missing {
+				err = journal.record(step, stateDone, Action{Op: actionMkdir, Path: dir})
+				if err != nil {
+					return err
+				}
+			}
+
+		case actionMove, actionRename:
+			// An interrupted run may have moved the file without getting the chance to mark it
+			if resuming && !exists(a.From) && exists(a.To) {
+				err := journal.record(step, stateDone, a)
+				if err != nil {
+					return err
+				}
+				applied = append(applied, a)
+				continue
+			}
+
+			// os.Rename will happily overwrite a file, which is never what we want
+			if exists(a.To) {
+				return errors.New("refusing to overwrite \"" + a.To + "\"")
+			}
+
+			err := os.Rename(a.From, a.To)
+			if err != nil {
+				return errors.Join(errors.New("trouble while moving \""+a.From+"\""), err)
+			}
+
+			if a.Op == actionRename {
+				fmt.Println("Renamed", filepath.Base(a.From), "to", filepath.Base(a.To))
+			} else if filepath.Base(a.From) == filepath.Base(a.To) {
+				fmt.Println("Moved", filepath.Base(a.From), "to", filepath.Dir(a.To))
+			} else {
+				fmt.Println("Moved", a.From, "to", a.To)
+			}
+
+			err = journal.record(step, stateDone, a)
+			if err != nil {
+				return err
+			}
+			applied = append(applied, a)
+
+		case actionCopy:
+			// An interrupted run may have finished the copy, which is only put in place once checked
+			if resuming && exists(a.To) {
+				sum, err := sameFiles(a.From, a.To)
+				if err != nil {
+					return err
+				}
+				sums[a.To] = sum
+				err = journal.record(step, stateDone, a)
+				if err != nil {
+					return err
+				}
+				applied = append(applied, a)
+				continue
+			}
+
+			if exists(a.To) {
+				return errors.New("refusing to overwrite \"" + a.To + "\"")
+			}
+
+			sum, err := copyFile(a.From, a.To)
+			if err != nil {
+				return err
+			}
+			sums[a.To] = sum
+			fmt.Println("Copied", filepath.Base(a.From), "to", filepath.Dir(a.To))
+
+			err = journal.record(step, stateDone, a)
+			if err != nil {
+				return err
+			}
+			applied = append(applied, a)
+
+		case actionDelete:
+			// Same as below, the file may already be gone
+			if resuming && !exists(a.Path) {
+				err := journal.record(step, stateDone, a)
+				if err != nil {
+					return err
+				}
+				applied = append(applied, a)
+				continue
+			}
+
+			// Never delete the last good copy of a file
+			_, err := sameFiles(a.Path, a.From)
+			if err != nil {
+				return errors.Join(errors.New("refusing to delete \""+a.Path+"\""), err)
+			}
+
+			err = os.Remove(a.Path)
+			if err != nil {
+				return errors.Join(errors.New("trouble while deleting \""+a.Path+"\""), err)
+			}
+			fmt.Println("Deleted", a.Path)
+
+			err = journal.record(step, stateDone, a)
+			if err != nil {
+				return err
+			}
+			applied = append(applied, a)
+
+		case actionRmdir:
+			// Same as above, the folder may already be gone
+			if resuming && !exists(a.Path) {
+				err := journal.record(step, stateDone, a)
+				if err != nil {
+					return err
+				}
+				applied = append(applied, a)
+				continue
+			}
+
+			err := os.Remove(a.Path)
+			if err != nil {
+				return errors.Join(errors.New("trouble while deleting \""+a.Path+"\""), err)
+			}
+			fmt.Println("Removed empty directory", a.Path)
+
+			err = journal.record(step, stateDone, a)
+			if err != nil {
+				return err
+			}
+			applied = append(applied, a)
+		}
+	}
+
+	// Keep the checksums of moved files under their new paths
+	err = p.syncManifest(journal, applied, sums)
+	applied = nil
+	if err != nil {
+		return err
+	}
+
+	err = journal.record(len(p.Actions), stateCommitted, Action{})
+	if err != nil {
+		return err
+	}
+
+	// Commands that only read the archive never write the index, so it is kept up to date here
+	return updateIndex(p.Dir)
+}
+
+// Brings the manifest up to date with actions that happened, then notes in the journal that it is
+func (p *Plan) syncManifest(journal *Journal, applied []Action, sums map[string]string) error {
+	err := updateManifest(p.Dir, applied, sums)
+	if err != nil {
+		return err
+	}
+	return journal.record(len(p.Actions), stateManifest, Action{})
+}
+
+// True if anything exists at the path
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return !os.IsNotExist(err)
+}
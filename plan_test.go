@@ -0,0 +1,109 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	plan_test.go
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Writes a small file holding its own name, so it can be told apart after being moved around
+func writeTestFile(t *testing.T, path string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(filepath.Base(path)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Fails unless the file at path holds what writeTestFile wrote for the file called name
+func expectTestFile(t *testing.T, path, name string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != name {
+		t.Errorf("%s holds %q, expected %q", filepath.Base(path), data, name)
+	}
+}
+
+func TestRenameAllSwap(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "20230101-001_granite_master.tif")
+	b := filepath.Join(dir, "20230101-002_granite_master.tif")
+	writeTestFile(t, a)
+	writeTestFile(t, b)
+	writeTestFile(t, filepath.Join(dir, "20230101-001_granite_master.xmp"))
+
+	p := newPlan("modify", dir)
+	err := p.renameAll([]Action{{From: a, To: b}, {From: b, To: a}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.followSidecars()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectTestFile(t, a, "20230101-002_granite_master.tif")
+	expectTestFile(t, b, "20230101-001_granite_master.tif")
+	expectTestFile(t, filepath.Join(dir, "20230101-002_granite_master.xmp"), "20230101-001_granite_master.xmp")
+	if exists(filepath.Join(dir, "20230101-001_granite_master.xmp")) {
+		t.Error("the sidecar was left behind")
+	}
+}
+
+func TestRenameAllChain(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range []string{"20230101-001_granite_master.tif", "20230101-002_granite_master.tif", "20230101-003_granite_master.tif"} {
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	writeTestFile(t, paths[0])
+	writeTestFile(t, paths[1])
+
+	p := newPlan("modify", dir)
+	err := p.renameAll([]Action{{From: paths[0], To: paths[1]}, {From: paths[1], To: paths[2]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Actions) != 2 {
+		t.Fatalf("a chain needs no temporary names, got %d actions", len(p.Actions))
+	}
+	err = p.execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectTestFile(t, paths[1], "20230101-001_granite_master.tif")
+	expectTestFile(t, paths[2], "20230101-002_granite_master.tif")
+}
+
+func TestRenameAllTaken(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "20230101-001_granite_master.tif")
+	b := filepath.Join(dir, "20230101-002_granite_master.tif")
+	writeTestFile(t, a)
+	writeTestFile(t, b)
+
+	// The file at b isn't being renamed, so it can't make room
+	p := newPlan("modify", dir)
+	err := p.renameAll([]Action{{From: a, To: b}})
+	if err == nil {
+		t.Error("expected renaming onto a file that stays put to fail")
+	}
+}
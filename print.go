@@ -0,0 +1,217 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	print.go
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/karlramberg/loupe/photo"
+)
+
+// One file of a photograph on the timeline
+type TimelineFile struct {
+	Class      string `json:"class"`
+	Group      string `json:"group"`
+	Version    string `json:"version"`
+	Subversion string `json:"subversion"`
+	Extension  string `json:"extension"`
+	Path       string `json:"path"`
+}
+
+// Every file sharing one identifier
+type TimelineEntry struct {
+	Identifier string         `json:"identifier"`
+	Date       string         `json:"date"`
+	Letter     string         `json:"letter"`
+	Number     string         `json:"number"`
+	Files      []TimelineFile `json:"files"`
+}
+
+// The filters print can narrow the timeline down with. Empty values don't filter anything
+type TimelineFilter struct {
+	from  string
+	to    string
+	class string
+	group string
+}
+
+func printTimeline(dir string, filter TimelineFilter, format string) error {
+	// Check that the -a flag was used
+	if dir == "" {
+		return errors.New("provide an archive directory using the -a flag")
+	}
+
+	// Check that the given directory exists
+	stats, err := os.Stat(dir)
+	if os.IsNotExist(err) || !stats.IsDir() {
+		return errors.New("directory \"" + dir + "\" not found")
+	}
+
+	// Check the filters and format before doing any work
+	if filter.from != "" {
+		if valid, err := photo.ValidDate(filter.from); !valid {
+			return errors.Join(errors.New("invalid -from date"), err)
+		}
+	}
+	if filter.to != "" {
+		if valid, err := photo.ValidDate(filter.to); !valid {
+			return errors.Join(errors.New("invalid -to date"), err)
+		}
+	}
+	if format != "table" && format != "csv" && format != "json" {
+		return errors.New("invalid format. Use table, csv or json")
+	}
+
+	// Get a list of image files in the directory and its subdirectories, from the index if there is one
+	files, err := getArchiveFiles(dir, false)
+	if err != nil {
+		return errors.Join(errors.New("trouble getting image files from \""+dir+"\""), err)
+	}
+
+	timeline := getTimeline(files, filter)
+
+	switch format {
+	case "csv":
+		return writeTimelineCSV(timeline)
+	case "json":
+		return writeTimelineJSON(timeline)
+	}
+
+	fmt.Println("Loupe", loupeVersion, "-", "Print")
+	fmt.Print(getTimelineTable(timeline))
+	fmt.Println(len(timeline), "photograph(s)")
+	return nil
+}
+
+// Groups every validly named file that passes the filters by its identifier, in timeline order
+func getTimeline(files []ScannedFile, filter TimelineFilter) []TimelineEntry {
+	entries := make(map[string]*TimelineEntry)
+	for _, file := range files {
+		photograph := file.Photograph
+		if file.Err != nil || !filter.matches(photograph) {
+			continue
+		}
+
+		identifier := photograph.Identifier()
+		entry, ok := entries[identifier]
+		if !ok {
+			entry = &TimelineEntry{Identifier: identifier, Date: photograph.Date, Letter: photograph.Letter, Number: photograph.Number}
+			entries[identifier] = entry
+		}
+
+		entry.Files = append(entry.Files, TimelineFile{
+			Class:      photograph.Class,
+			Group:      photograph.Group,
+			Version:    photograph.Version,
+			Subversion: photograph.Subversion,
+			Extension:  photograph.Extension,
+			Path:       file.Path,
+		})
+	}
+
+	// Identifiers start with the date, so sorting them puts the timeline in order
+	timeline := []TimelineEntry{}
+	for _, entry := range entries {
+		slices.SortFunc(entry.Files, func(a, b TimelineFile) int {
+			return strings.Compare(a.Path, b.Path)
+		})
+		timeline = append(timeline, *entry)
+	}
+	slices.SortFunc(timeline, func(a, b TimelineEntry) int {
+		return strings.Compare(a.Identifier, b.Identifier)
+	})
+	return timeline
+}
+
+// True if the photograph passes every filter that was given
+func (f TimelineFilter) matches(photograph photo.Photograph) bool {
+	if f.from != "" && photograph.Date < f.from {
+		return false
+	}
+	if f.to != "" && photograph.Date > f.to {
+		return false
+	}
+	if f.class != "" && photograph.Class != f.class {
+		return false
+	}
+	if f.group != "" && photograph.Group != f.group {
+		return false
+	}
+	return true
+}
+
+// Constructs a table with a line for each identifier, and an indented line for each of its files
+func getTimelineTable(timeline []TimelineEntry) (table string) {
+	// Find how wide the grouping and version columns need to be
+	var groupWidth, versionWidth int
+	for _, entry := range timeline {
+		for _, f := range entry.Files {
+			groupWidth = max(groupWidth, len(joinOptional(f.Class, f.Group)))
+			versionWidth = max(versionWidth, len(joinOptional(f.Version, f.Subversion)))
+		}
+	}
+
+	for _, entry := range timeline {
+		table += entry.Identifier + "\n"
+		for _, f := range entry.Files {
+			table += fmt.Sprintf("    %-*s  %-*s  %s\n",
+				groupWidth, joinOptional(f.Class, f.Group),
+				versionWidth, joinOptional(f.Version, f.Subversion),
+				filepath.Dir(f.Path))
+		}
+	}
+	return
+}
+
+// Joins a pair like class and group with a hyphen the way a filename would, leaving out "none"
+func joinOptional(first, second string) string {
+	if first == "none" {
+		return second
+	}
+	if second == "none" {
+		return first
+	}
+	return first + "-" + second
+}
+
+// Writes the timeline to standard output with a row for every file
+func writeTimelineCSV(timeline []TimelineEntry) error {
+	writer := csv.NewWriter(os.Stdout)
+	writer.Write([]string{"identifier", "date", "letter", "number", "class", "group", "version", "subversion", "extension", "path"})
+	for _, entry := range timeline {
+		for _, f := range entry.Files {
+			writer.Write([]string{
+				entry.Identifier, entry.Date, entry.Letter, entry.Number,
+				f.Class, f.Group, f.Version, f.Subversion, f.Extension, f.Path,
+			})
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return errors.Join(errors.New("trouble while writing the timeline"), err)
+	}
+	return nil
+}
+
+// Writes the timeline to standard output as JSON
+func writeTimelineJSON(timeline []TimelineEntry) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "\t")
+	err := encoder.Encode(timeline)
+	if err != nil {
+		return errors.Join(errors.New("trouble while writing the timeline"), err)
+	}
+	return nil
+}
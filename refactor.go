@@ -7,13 +7,16 @@
 package main
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+
+	"github.com/karlramberg/loupe/photo"
 )
 
-func refactor(dir, typeStr, old, new string) error {
+func refactor(dir, typeStr, old, new string, pick, dryRun bool, jsonPath string) error {
 	fmt.Println("Loupe", loupeVersion, "-", "Rename")
 
 	if dir == "" {
@@ -25,67 +28,150 @@ func refactor(dir, typeStr, old, new string) error {
 		return errors.New("directory \"" + dir + "\" not found")
 	}
 
-	validType, err := validType(typeStr)
+	validType, err := photo.ValidType(typeStr)
 	if !validType {
 		return err
 	}
 
-	validOld, err := validWord(old)
-	validNew, err2 := validWord(new)
+	validOld, err := photo.ValidWord(old)
+	validNew, err2 := photo.ValidWord(new)
 	if !validOld || !validNew {
 		return errors.Join(err, err2)
 	}
 
-	files, err := getImageFiles(dir)
+	// Setup a scanner to standard input for the user to answer any questions
+	scanner := bufio.NewScanner(os.Stdin)
+
+	// Deal with a command that was cut off before going any further
+	if !dryRun {
+		okay, err := recoverInterrupted(dir, scanner)
+		if err != nil || !okay {
+			return err
+		}
+	}
+
+	archive, err := scanArchive(dir)
 	if err != nil {
-		return errors.Join(errors.New("trouble getting images files from \""+dir+"\""), err)
+		return err
 	}
 
-	if len(files) == 0 {
+	if len(archive.files) == 0 && len(archive.invalidFiles) == 0 {
 		return errors.New("no image files found in \"" + dir + "\"")
 	}
 
-	var renameCount int
-	for _, file := range files {
-		var photograph Photograph
-		err := photograph.init(filepath.Base(file))
+	// Every file is sorted along with the renames, so ask for the same confirmation sort does
+	total := len(archive.files) + len(archive.invalidFiles)
+	if float64(len(archive.photos)) < (0.66*float64(total)) && !dryRun {
+		okay, err := promptConfimation(scanner,
+			"Less than 2/3rds of images in this directory are named correctly, do you wish to proceed?")
 		if err != nil {
-			continue
+			return err
 		}
 
-		// Save the photograph's old location before it is changed
-		oldDir := photograph.directory()
-
-		if typeStr == "class" && photograph.class == old {
-			photograph.class = new
-		} else if typeStr == "group" && photograph.group == old {
-			photograph.group = new
-		} else if typeStr == "version" && photograph.version == old {
-			photograph.version = new
-		} else if typeStr == "subversion" && photograph.subversion == old {
-			photograph.subversion = new
+		if !okay {
+			fmt.Println("Aborting!")
+			return nil
+		}
+	}
+
+	/*
+		NOTE: Renaming and sorting are planned together, so every file is moved straight from
+		where it is now to where its new name says it should be. A file is only given its new
+		name if that name isn't already taken in the folder it sits in, the same check a plain
+		rename in place would make.
+	*/
+	var candidates []int
+	var candidateFiles []string
+	renames := make(map[int]photo.Photograph)
+	for i, file := range archive.files {
+		photograph := archive.photos[i]
+
+		renamed := photograph
+		if typeStr == "class" && renamed.Class == old {
+			renamed.Class = new
+		} else if typeStr == "group" && renamed.Group == old {
+			renamed.Group = new
+		} else if typeStr == "version" && renamed.Version == old {
+			renamed.Version = new
+		} else if typeStr == "subversion" && renamed.Subversion == old {
+			renamed.Subversion = new
 		}
 
-		// Update only the filename with the new grouping
-		oldpath := file
-		newpath := filepath.Join(dir, filepath.Join(oldDir, photograph.filename()))
-		_, err = os.Stat(newpath)
-		if os.IsNotExist(err) && oldpath != newpath {
-			err = os.Rename(oldpath, newpath)
-			if err != nil {
-				return errors.Join(errors.New("trouble while renaming \""+oldpath+"\""), err)
-			} else {
-				fmt.Println("Renamed", oldpath, "to", filepath.Base(newpath))
-				renameCount++
-			}
+		if renamed != photograph && !archive.known[filepath.Join(filepath.Dir(file), renamed.Filename())] {
+			candidates = append(candidates, i)
+			candidateFiles = append(candidateFiles, file)
+			renames[i] = renamed
 		}
 	}
 
-	fmt.Printf("%d files renamed\n", renameCount)
-	fmt.Println()
+	// Let the user pick which of the files to rename, the rest keep their names but are still sorted
+	if pick && len(candidates) > 0 {
+		selections, err := selectFiles(scanner, candidateFiles, func(i int) string {
+			return "Renamed to " + renames[candidates[i]].Filename()
+		})
+		if err != nil {
+			return err
+		}
+
+		picked := make(map[int]photo.Photograph)
+		for _, selection := range selections {
+			picked[candidates[selection]] = renames[candidates[selection]]
+		}
+		renames = picked
+	}
+
+	var validPhotos []photo.Photograph
+	for i, photograph := range archive.photos {
+		if renamed, ok := renames[i]; ok {
+			photograph = renamed
+		}
+		validPhotos = append(validPhotos, photograph)
+	}
+	renameCount := len(renames)
+
+	// Plan the renames along with the sort that moves the files to their new folders
+	plan := newPlan("refactor", dir)
+	plan.known = archive.known
+	_, err = plan.sortFiles(dir, validPhotos, archive.files, archive.invalidFiles, false)
+	if err != nil {
+		return err
+	}
 
-	// Sort the files using their new grouping
-	sort(dir)
+	// Sidecars are renamed and moved along with their photographs
+	err = plan.followSidecars()
+	if err != nil {
+		return err
+	}
+
+	_, err = plan.cleanEmptyDirs(dir)
+	if err != nil {
+		return err
+	}
+
+	// A dry run only shows the plan, there is nothing to confirm
+	if dryRun {
+		return plan.run(dryRun, jsonPath)
+	}
+
+	// Ask the user for a final confirmation of the changes
+	fmt.Print(plan.table())
+	okay, err := promptConfimation(scanner, "Do these changes look okay?")
+	if err != nil {
+		return err
+	}
+
+	if !okay {
+		fmt.Println("Aborting!")
+		return nil
+	}
+
+	fmt.Println("Okay!")
+	err = plan.run(dryRun, jsonPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d files renamed\n", renameCount)
 
 	return nil
 }
@@ -0,0 +1,320 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	rolls.go
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/karlramberg/loupe/photo"
+)
+
+/*
+	The roll registry is a plain text file at _loupe/rolls.txt with a line for every roll of film,
+	holding what the filenames can't: the film stock, camera, ISO, developer and any notes. Each
+	line is the roll and then those five, separated by |, like
+
+		20230615-B | Kodak Portra 400 | Nikon F3 | 400 | C-41 | Berlin, pushed a stop
+
+	A roll is the date and letter of its identifiers. Lines starting with # are comments. The file
+	is meant to be read and edited by hand as much as with "loupe rolls -add", which keeps the
+	comments and order it finds, and the notes are everything after the fifth |, so they can hold
+	one too.
+*/
+
+const rollsName = "rolls.txt"
+
+const rollsHeader = `# Loupe roll registry, one roll of film per line:
+# roll | film stock | camera | iso | developer | notes
+`
+
+var rollPattern = regexp.MustCompile("^([0-9]{8})-(Z*[A-Z])$")
+
+// One roll of film in the registry
+type Roll struct {
+	Roll      string `json:"roll"`
+	Stock     string `json:"stock"`
+	Camera    string `json:"camera"`
+	ISO       string `json:"iso"`
+	Developer string `json:"developer"`
+	Notes     string `json:"notes"`
+}
+
+// A roll with the frames found for it in the archive
+type RollReport struct {
+	Roll
+	Registered bool  `json:"registered"`
+	Frames     []int `json:"frames"`
+	Missing    []int `json:"missing"`
+}
+
+// Reads the roll registry of an archive, along with the file so it can be written back. An
+// archive without one has no rolls registered
+func readRolls(dir string) ([]Roll, *ListFile, error) {
+	list, err := readListFile(dir, rollsName, "the roll registry", rollsHeader, 6)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rolls []Roll
+	seen := make(map[string]bool)
+	err = list.entries(func(fields []string) error {
+		roll := Roll{fields[0], fields[1], fields[2], fields[3], fields[4], fields[5]}
+		if err := checkRoll(roll.Roll); err != nil {
+			return err
+		}
+		if seen[roll.Roll] {
+			return errors.New("roll " + roll.Roll + " is repeated")
+		}
+		seen[roll.Roll] = true
+		rolls = append(rolls, roll)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return rolls, list, nil
+}
+
+// Writes the roll registry back, leaving every comment and roll that didn't change as it was
+func writeRolls(list *ListFile, rolls []Roll) error {
+	entries := make([][]string, len(rolls))
+	for i, roll := range rolls {
+		entries[i] = []string{roll.Roll, roll.Stock, roll.Camera, roll.ISO, roll.Developer, roll.Notes}
+	}
+	return list.write(entries, func(fields []string) string {
+		return fields[0]
+	})
+}
+
+// Checks a roll is a real date and a roll letter, like 20230615-B
+func checkRoll(roll string) error {
+	parts := rollPattern.FindStringSubmatch(roll)
+	if parts == nil {
+		return errors.New("\"" + roll + "\" isn't a roll, use the date and letter like 20230615-B")
+	}
+	if valid, err := photo.ValidDate(parts[1]); !valid {
+		return err
+	}
+	return nil
+}
+
+// Lists every roll of film in the archive and the registry, with how many frames each has and which
+// are missing. With add set, that roll is added to the registry or has the details given replaced
+func rolls(dir, add string, details Roll, format string) error {
+	// Check that the -a flag was used
+	if dir == "" {
+		return errors.New("provide an archive directory using the -a flag")
+	}
+
+	// Check that the given directory exists
+	stats, err := os.Stat(dir)
+	if os.IsNotExist(err) || !stats.IsDir() {
+		return errors.New("directory \"" + dir + "\" not found")
+	}
+
+	if format != "table" && format != "json" {
+		return errors.New("invalid format. Use table or json")
+	}
+
+	registry, list, err := readRolls(dir)
+	if err != nil {
+		return err
+	}
+
+	if add != "" {
+		registry, err = addRoll(registry, strings.ToUpper(add), details)
+		if err != nil {
+			return err
+		}
+		err = writeRolls(list, registry)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Get a list of image files in the directory and its subdirectories, from the index if there is one
+	files, err := getArchiveFiles(dir, false)
+	if err != nil {
+		return errors.Join(errors.New("trouble getting image files from \""+dir+"\""), err)
+	}
+
+	reports := make(map[string]*RollReport)
+	for _, roll := range registry {
+		reports[roll.Roll] = &RollReport{Roll: roll, Registered: true}
+	}
+
+	// Every version of a frame shares its identifier, so each frame is only counted once
+	for _, file := range files {
+		photograph := file.Photograph
+		if file.Err != nil || photograph.Letter == photo.None {
+			continue
+		}
+
+		roll := photograph.Date + "-" + photograph.Letter
+		report, ok := reports[roll]
+		if !ok {
+			report = &RollReport{Roll: Roll{Roll: roll}}
+			reports[roll] = report
+		}
+
+		frame, _ := strconv.Atoi(photograph.Number)
+		if !slices.Contains(report.Frames, frame) {
+			report.Frames = append(report.Frames, frame)
+		}
+	}
+
+	found := []RollReport{}
+	unregistered := 0
+	for _, report := range reports {
+		slices.Sort(report.Frames)
+		report.Missing = missingFrames(report.Frames)
+		if report.Frames == nil {
+			report.Frames = []int{}
+		}
+		if !report.Registered {
+			unregistered++
+		}
+		found = append(found, *report)
+	}
+	slices.SortFunc(found, func(a, b RollReport) int {
+		return strings.Compare(a.Roll.Roll, b.Roll.Roll)
+	})
+
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "\t")
+		err := encoder.Encode(found)
+		if err != nil {
+			return errors.Join(errors.New("trouble while writing the rolls"), err)
+		}
+		return nil
+	}
+
+	fmt.Println("Loupe", loupeVersion, "-", "Rolls")
+	fmt.Print(getRollTable(found))
+	fmt.Println(len(found), "roll(s),", unregistered, "not in the registry")
+	if unregistered > 0 {
+		fmt.Println("Add the details of a roll with -add, or edit " + filepath.Join(dir, loupeDir, rollsName))
+	}
+	return nil
+}
+
+// Adds a roll to the registry, or replaces the details given for a roll already in it
+func addRoll(registry []Roll, roll string, details Roll) ([]Roll, error) {
+	err := checkRoll(roll)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range []string{details.Stock, details.Camera, details.ISO, details.Developer} {
+		if strings.Contains(field, "|") {
+			return nil, errors.New("\"" + field + "\" can't hold a |, only the notes can")
+		}
+	}
+	for _, field := range []string{details.Stock, details.Camera, details.ISO, details.Developer, details.Notes} {
+		if strings.Contains(field, "\n") {
+			return nil, errors.New("\"" + field + "\" can't hold a new line")
+		}
+	}
+	if valid, _ := regexp.MatchString("^[0-9]*$", details.ISO); !valid {
+		return nil, errors.New("the ISO has to be a whole number, put pushes and pulls in the notes")
+	}
+
+	i := slices.IndexFunc(registry, func(r Roll) bool {
+		return r.Roll == roll
+	})
+	if i == -1 {
+		registry = append(registry, Roll{Roll: roll})
+		i = len(registry) - 1
+	}
+
+	// Only replace the details that were given
+	if details.Stock != "" {
+		registry[i].Stock = details.Stock
+	}
+	if details.Camera != "" {
+		registry[i].Camera = details.Camera
+	}
+	if details.ISO != "" {
+		registry[i].ISO = details.ISO
+	}
+	if details.Developer != "" {
+		registry[i].Developer = details.Developer
+	}
+	if details.Notes != "" {
+		registry[i].Notes = details.Notes
+	}
+	return registry, nil
+}
+
+// Lists the frames between the first frame and the last one found that aren't there. Some cameras
+// start at frame 0, so it is never counted as missing
+func missingFrames(frames []int) (missing []int) {
+	if len(frames) == 0 {
+		return []int{}
+	}
+
+	missing = []int{}
+	for frame := 1; frame < frames[len(frames)-1]; frame++ {
+		if !slices.Contains(frames, frame) {
+			missing = append(missing, frame)
+		}
+	}
+	return
+}
+
+// Writes a list of frames as short as it can, like 03-05, 09
+func frameRanges(frames []int) string {
+	var ranges []string
+	for i := 0; i < len(frames); i++ {
+		first := frames[i]
+		for i+1 < len(frames) && frames[i+1] == frames[i]+1 {
+			i++
+		}
+		if frames[i] == first {
+			ranges = append(ranges, fmt.Sprintf("%02d", first))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%02d-%02d", first, frames[i]))
+		}
+	}
+	return strings.Join(ranges, ", ")
+}
+
+// Constructs a table with a line for every roll, marking the rolls missing from the registry
+func getRollTable(found []RollReport) (table string) {
+	var rollWidth, stockWidth, cameraWidth int
+	for _, report := range found {
+		rollWidth = max(rollWidth, len(report.Roll.Roll))
+		stockWidth = max(stockWidth, len(report.Stock))
+		cameraWidth = max(cameraWidth, len(report.Camera))
+	}
+
+	for _, report := range found {
+		table += fmt.Sprintf("%-*s  %3d frame(s)", rollWidth, report.Roll.Roll, len(report.Frames))
+		if !report.Registered {
+			table += "  not in the registry\n"
+		} else {
+			line := fmt.Sprintf("  %-*s  %-*s  %4s  %s", stockWidth, report.Stock, cameraWidth, report.Camera, report.ISO, report.Developer)
+			table += strings.TrimRight(line, " ") + "\n"
+			if report.Notes != "" {
+				table += "    " + report.Notes + "\n"
+			}
+		}
+		if len(report.Missing) > 0 {
+			table += "    missing " + frameRanges(report.Missing) + "\n"
+		}
+	}
+	return
+}
@@ -0,0 +1,660 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	serve.go
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/karlramberg/loupe/exif"
+)
+
+/*
+	NOTE: The server only ever reads the archive. It answers GET and HEAD, and only hands out files
+	that are in its list of image files, so a crafted path can't reach anything else on the disk.
+	The list comes from the index if the archive has one, the same as print and find, brought up
+	to date in memory without writing the index. It is read again at most every few seconds so
+	renames made while it runs show up. Thumbnails are made in memory and never written anywhere.
+*/
+
+// How long a list of the archive's files is used before reading the archive again
+const serveRefresh = 5 * time.Second
+
+// The longest side of a thumbnail, in pixels
+const thumbnailSize = 320
+
+// Stop keeping thumbnails once there are this many, and start over
+const maxThumbnails = 4000
+
+// Formats every browser can draw as they are
+var browserExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
+
+// A file as the web interface and its API describe it. Paths are relative to the archive and use
+// forward slashes on every system
+type ServedFile struct {
+	Path       string `json:"path"`
+	Filename   string `json:"filename"`
+	Valid      bool   `json:"valid"`
+	Problem    string `json:"problem,omitempty"`
+	Identifier string `json:"identifier,omitempty"`
+	Date       string `json:"date,omitempty"`
+	Letter     string `json:"letter,omitempty"`
+	Number     string `json:"number,omitempty"`
+	Class      string `json:"class,omitempty"`
+	Group      string `json:"group,omitempty"`
+	Version    string `json:"version,omitempty"`
+	Subversion string `json:"subversion,omitempty"`
+	Extension  string `json:"extension,omitempty"`
+	Thumbnail  string `json:"thumbnail"`
+	Image      string `json:"image"`
+}
+
+// One folder of the archive and what is directly inside it
+type ServedFolder struct {
+	Path    string       `json:"path"`
+	Folders []string     `json:"folders"`
+	Files   []ServedFile `json:"files"`
+}
+
+// Everything the server keeps between requests
+type Server struct {
+	dir        string
+	mutex      sync.Mutex
+	listing    *Listing
+	thumbnails map[string][]byte
+	slots      chan struct{} // Limits how many thumbnails are made at once
+}
+
+// The files of the archive at one point in time. It is never changed once made, a new one takes
+// its place instead
+type Listing struct {
+	files   []ServedFile
+	scanned []ScannedFile // The same files with archive relative paths, for queries and the timeline
+	byPath  map[string]int
+	loaded  time.Time
+}
+
+func serve(dir, addr string) error {
+	fmt.Println("Loupe", loupeVersion, "-", "Serve")
+
+	// Check that the -a flag was used
+	if dir == "" {
+		return errors.New("provide an archive directory using the -a flag")
+	}
+
+	// Check that the given directory exists
+	stats, err := os.Stat(dir)
+	if os.IsNotExist(err) || !stats.IsDir() {
+		return errors.New("directory \"" + dir + "\" not found")
+	}
+
+	s := newServer(dir)
+
+	// Read the archive once up front, so a problem with it shows up before the server starts
+	_, err = s.archive()
+	if err != nil {
+		return errors.Join(errors.New("trouble getting image files from \""+dir+"\""), err)
+	}
+
+	server := &http.Server{Addr: addr, Handler: s.handler()}
+
+	// Stop cleanly on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
+	fmt.Println("Browse", dir, "at http://"+addr+"/, press Ctrl-C to stop")
+	err = server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		return errors.Join(errors.New("trouble serving on \""+addr+"\""), err)
+	}
+
+	fmt.Println("Stopped")
+	return nil
+}
+
+func newServer(dir string) *Server {
+	return &Server{
+		dir:        dir,
+		thumbnails: make(map[string][]byte),
+		slots:      make(chan struct{}, runtime.NumCPU()),
+	}
+}
+
+// Routes every page and API call, turning away anything but reading
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleBrowse)
+	mux.HandleFunc("/timeline", s.handleTimeline)
+	mux.HandleFunc("/photo/", s.handlePhoto)
+	mux.HandleFunc("/thumbnail/", s.handleThumbnail)
+	mux.HandleFunc("/image/", s.handleImage)
+	mux.HandleFunc("/api/folders/", s.handleAPIFolder)
+	mux.HandleFunc("/api/photos", s.handleAPIPhotos)
+	mux.HandleFunc("/api/photos/", s.handleAPIPhoto)
+	mux.HandleFunc("/api/timeline", s.handleAPITimeline)
+	return readOnly(mux)
+}
+
+// Turns away anything that could change something
+func readOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Loupe only reads the archive", http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Gets the list of files in the archive, reading it again if it is getting old
+func (s *Server) archive() (*Listing, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.listing != nil && time.Since(s.listing.loaded) < serveRefresh {
+		return s.listing, nil
+	}
+
+	files, err := getArchiveFiles(s.dir, false)
+	if err != nil {
+		return nil, err
+	}
+
+	listing := &Listing{
+		files:   make([]ServedFile, len(files)),
+		scanned: make([]ScannedFile, len(files)),
+		byPath:  make(map[string]int),
+		loaded:  time.Now(),
+	}
+	for i, file := range files {
+		rel, err := filepath.Rel(s.dir, file.Path)
+		if err != nil {
+			return nil, err
+		}
+		rel = filepath.ToSlash(rel)
+
+		listing.files[i] = newServedFile(rel, file)
+		listing.scanned[i] = ScannedFile{Path: rel, Photograph: file.Photograph, Err: file.Err}
+		listing.byPath[rel] = i
+	}
+	s.listing = listing
+	return listing, nil
+}
+
+// Looks a file up by its path relative to the archive
+func (s *Server) lookup(rel string) (ServedFile, bool) {
+	listing, err := s.archive()
+	if err != nil {
+		return ServedFile{}, false
+	}
+
+	i, ok := listing.byPath[rel]
+	if !ok {
+		return ServedFile{}, false
+	}
+	return listing.files[i], true
+}
+
+func newServedFile(rel string, file ScannedFile) ServedFile {
+	served := ServedFile{
+		Path:      rel,
+		Filename:  path.Base(rel),
+		Valid:     file.Err == nil,
+		Thumbnail: "/thumbnail/" + escapePath(rel),
+		Image:     "/image/" + escapePath(rel),
+	}
+	if file.Err != nil {
+		served.Problem = strings.ReplaceAll(file.Err.Error(), "\n", ", ")
+		return served
+	}
+
+	photograph := file.Photograph
+	served.Identifier = photograph.Identifier()
+	served.Date = photograph.Date
+	served.Letter = photograph.Letter
+	served.Number = photograph.Number
+	served.Class = photograph.Class
+	served.Group = photograph.Group
+	served.Version = photograph.Version
+	served.Subversion = photograph.Subversion
+	served.Extension = photograph.Extension
+	return served
+}
+
+// Escapes each part of a path for a URL, leaving the slashes between them alone
+func escapePath(rel string) string {
+	parts := strings.Split(rel, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+// Lists the folders and files directly inside a folder of the archive. Only folders with image
+// files somewhere in them are listed, the same way the archive is scanned
+func (s *Server) folder(rel string) (ServedFolder, bool) {
+	listing, err := s.archive()
+	if err != nil {
+		return ServedFolder{}, false
+	}
+
+	folder := ServedFolder{Path: rel, Folders: []string{}, Files: []ServedFile{}}
+	prefix := ""
+	if rel != "" {
+		prefix = rel + "/"
+	}
+
+	found := rel == ""
+	for _, file := range listing.files {
+		if !strings.HasPrefix(file.Path, prefix) {
+			continue
+		}
+		found = true
+
+		rest := strings.TrimPrefix(file.Path, prefix)
+		if sub, _, nested := strings.Cut(rest, "/"); nested {
+			if !slices.Contains(folder.Folders, sub) {
+				folder.Folders = append(folder.Folders, sub)
+			}
+		} else {
+			folder.Files = append(folder.Files, file)
+		}
+	}
+	slices.Sort(folder.Folders)
+	return folder, found
+}
+
+// The validly named files that match a query, or every file if there is no query
+func (s *Server) query(q string) ([]ServedFile, error) {
+	listing, err := s.archive()
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(q) == "" {
+		return listing.files, nil
+	}
+
+	query, err := parseQuery(strings.Fields(q))
+	if err != nil {
+		return nil, err
+	}
+
+	found := []ServedFile{}
+	for i, file := range listing.scanned {
+		if file.Err == nil && query.matches(file.Photograph) {
+			found = append(found, listing.files[i])
+		}
+	}
+	return found, nil
+}
+
+// The timeline of the validly named files that match a query
+func (s *Server) timeline(q string) ([]TimelineEntry, error) {
+	listing, err := s.archive()
+	if err != nil {
+		return nil, err
+	}
+
+	var query Query
+	if strings.TrimSpace(q) != "" {
+		query, err = parseQuery(strings.Fields(q))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var scanned []ScannedFile
+	for _, file := range listing.scanned {
+		if file.Err == nil && query.matches(file.Photograph) {
+			scanned = append(scanned, file)
+		}
+	}
+	return getTimeline(scanned, TimelineFilter{}), nil
+}
+
+// Every other file sharing an identifier with a file
+func (s *Server) related(file ServedFile) []ServedFile {
+	listing, err := s.archive()
+	if err != nil || !file.Valid {
+		return nil
+	}
+
+	related := []ServedFile{}
+	for _, other := range listing.files {
+		if other.Valid && other.Identifier == file.Identifier && other.Path != file.Path {
+			related = append(related, other)
+		}
+	}
+	return related
+}
+
+// Pages
+
+func (s *Server) handleBrowse(w http.ResponseWriter, r *http.Request) {
+	rel := strings.Trim(r.URL.Path, "/")
+	folder, ok := s.folder(rel)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Link back up to each folder above this one
+	var crumbs []crumb
+	if rel != "" {
+		parts := strings.Split(rel, "/")
+		for i, part := range parts {
+			crumbs = append(crumbs, crumb{Name: part, Link: "/" + escapePath(strings.Join(parts[:i+1], "/")) + "/"})
+		}
+	}
+
+	var folders []crumb
+	for _, sub := range folder.Folders {
+		folders = append(folders, crumb{Name: sub, Link: "/" + escapePath(path.Join(rel, sub)) + "/"})
+	}
+
+	s.render(w, "browse", map[string]any{
+		"Title":   "/" + rel,
+		"Crumbs":  crumbs,
+		"Folders": folders,
+		"Files":   folder.Files,
+	})
+}
+
+// A named link, for folders and the path to them
+type crumb struct {
+	Name string
+	Link string
+}
+
+func (s *Server) handleTimeline(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	timeline, err := s.timeline(q)
+	problem := ""
+	if err != nil {
+		problem = strings.ReplaceAll(err.Error(), "\n", ", ")
+	}
+
+	// Link each file on the timeline to its page
+	type entry struct {
+		TimelineEntry
+		Served []ServedFile
+	}
+	var entries []entry
+	for _, e := range timeline {
+		served := entry{TimelineEntry: e}
+		for _, f := range e.Files {
+			if file, ok := s.lookup(f.Path); ok {
+				served.Served = append(served.Served, file)
+			}
+		}
+		entries = append(entries, served)
+	}
+
+	s.render(w, "timeline", map[string]any{
+		"Title":   "Timeline",
+		"Query":   q,
+		"Problem": problem,
+		"Entries": entries,
+	})
+}
+
+func (s *Server) handlePhoto(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.lookup(strings.TrimPrefix(r.URL.Path, "/photo/"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	folder := "/"
+	if dir := path.Dir(file.Path); dir != "." {
+		folder += escapePath(dir) + "/"
+	}
+
+	s.render(w, "photo", map[string]any{
+		"Title":   file.Filename,
+		"File":    file,
+		"Folder":  folder,
+		"Related": s.related(file),
+	})
+}
+
+func (s *Server) render(w http.ResponseWriter, page string, data map[string]any) {
+	var html bytes.Buffer
+	err := pages.ExecuteTemplate(&html, page, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(html.Bytes())
+}
+
+// Images
+
+// Serves a file the browser can draw as it is, or the preview embedded in it
+func (s *Server) handleImage(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.lookup(strings.TrimPrefix(r.URL.Path, "/image/"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	full := filepath.Join(s.dir, filepath.FromSlash(file.Path))
+
+	if slices.Contains(browserExtensions, strings.ToLower(path.Ext(file.Path))) {
+		http.ServeFile(w, r, full)
+		return
+	}
+
+	preview, err := exif.PreviewFile(full)
+	if err != nil {
+		writePlaceholder(w, file)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Write(preview)
+}
+
+// Serves a small JPEG of a file, or a tile with its extension on it if it can't be drawn
+func (s *Server) handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.lookup(strings.TrimPrefix(r.URL.Path, "/thumbnail/"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	full := filepath.Join(s.dir, filepath.FromSlash(file.Path))
+
+	stats, err := os.Stat(full)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	key := file.Path + "@" + stats.ModTime().String()
+
+	s.mutex.Lock()
+	thumbnail, ok := s.thumbnails[key]
+	s.mutex.Unlock()
+
+	if !ok {
+		s.slots <- struct{}{}
+		thumbnail, err = makeThumbnail(full)
+		<-s.slots
+		if err != nil {
+			writePlaceholder(w, file)
+			return
+		}
+
+		s.mutex.Lock()
+		if len(s.thumbnails) >= maxThumbnails {
+			s.thumbnails = make(map[string][]byte)
+		}
+		s.thumbnails[key] = thumbnail
+		s.mutex.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "max-age=3600")
+	w.Write(thumbnail)
+}
+
+// Stands in for a file the browser can't draw, with its extension on it
+func writePlaceholder(w http.ResponseWriter, file ServedFile) {
+	w.Header().Set("Content-Type", "image/svg+xml")
+	pages.ExecuteTemplate(w, "placeholder", strings.ToUpper(strings.TrimPrefix(path.Ext(file.Path), ".")))
+}
+
+// Decodes a file, or the preview embedded in it, and shrinks it down to a thumbnail
+func makeThumbnail(full string) ([]byte, error) {
+	var img image.Image
+	if slices.Contains(browserExtensions, strings.ToLower(filepath.Ext(full))) {
+		file, err := os.Open(full)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		img, _, err = image.Decode(file)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		preview, err := exif.PreviewFile(full)
+		if err != nil {
+			return nil, err
+		}
+
+		img, err = jpeg.Decode(bytes.NewReader(preview))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var thumbnail bytes.Buffer
+	err := jpeg.Encode(&thumbnail, shrink(img, thumbnailSize), &jpeg.Options{Quality: 80})
+	if err != nil {
+		return nil, err
+	}
+	return thumbnail.Bytes(), nil
+}
+
+// Scales an image down so its longest side is size, averaging a few pixels for every one kept.
+// Images already small enough are left as they are
+func shrink(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return img
+	}
+
+	newWidth, newHeight := size, max(1, height*size/width)
+	if height > width {
+		newWidth, newHeight = max(1, width*size/height), size
+	}
+
+	// Up to 4 by 4 samples are taken from the area each new pixel covers
+	const samples = 4
+	shrunk := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		for x := 0; x < newWidth; x++ {
+			var r, g, b, count uint32
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					px := bounds.Min.X + (x*samples+sx)*width/(newWidth*samples)
+					py := bounds.Min.Y + (y*samples+sy)*height/(newHeight*samples)
+					cr, cg, cb, _ := img.At(px, py).RGBA()
+					r, g, b, count = r+cr, g+cg, b+cb, count+1
+				}
+			}
+			shrunk.Set(x, y, color.RGBA64{uint16(r / count), uint16(g / count), uint16(b / count), 0xFFFF})
+		}
+	}
+	return shrunk
+}
+
+// API
+
+func (s *Server) handleAPIFolder(w http.ResponseWriter, r *http.Request) {
+	folder, ok := s.folder(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/folders"), "/"))
+	if !ok {
+		writeAPIError(w, "folder not found", http.StatusNotFound)
+		return
+	}
+	writeAPI(w, folder)
+}
+
+// Every file, or the files matching the query in q, written the same way loupe find takes it
+func (s *Server) handleAPIPhotos(w http.ResponseWriter, r *http.Request) {
+	files, err := s.query(r.URL.Query().Get("q"))
+	if err != nil {
+		writeAPIError(w, strings.ReplaceAll(err.Error(), "\n", ", "), http.StatusBadRequest)
+		return
+	}
+	writeAPI(w, files)
+}
+
+// One file along with the other files sharing its identifier
+func (s *Server) handleAPIPhoto(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.lookup(strings.TrimPrefix(r.URL.Path, "/api/photos/"))
+	if !ok {
+		writeAPIError(w, "file not found", http.StatusNotFound)
+		return
+	}
+	writeAPI(w, struct {
+		ServedFile
+		Related []ServedFile `json:"related"`
+	}{file, s.related(file)})
+}
+
+func (s *Server) handleAPITimeline(w http.ResponseWriter, r *http.Request) {
+	timeline, err := s.timeline(r.URL.Query().Get("q"))
+	if err != nil {
+		writeAPIError(w, strings.ReplaceAll(err.Error(), "\n", ", "), http.StatusBadRequest)
+		return
+	}
+	writeAPI(w, timeline)
+}
+
+func writeAPI(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	encoder.Encode(value)
+}
+
+func writeAPIError(w http.ResponseWriter, problem string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	encoder.Encode(map[string]string{"error": problem})
+}
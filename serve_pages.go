@@ -0,0 +1,136 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	serve_pages.go
+*/
+
+package main
+
+import (
+	"html/template"
+
+	"github.com/karlramberg/loupe/photo"
+)
+
+/*
+	The pages loupe serve draws. They are plain HTML with the styles written into them and no
+	scripts, so they work without a connection and in any browser. Every page shares the header
+	in "top" and the footer in "bottom".
+*/
+
+var pages = template.Must(template.New("pages").Funcs(template.FuncMap{
+	// True if an optional attribute is there, letters, classes and subversions hold none otherwise
+	"has": func(value string) bool {
+		return value != "" && value != photo.None
+	},
+}).Parse(`
+{{define "top"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} - Loupe</title>
+<style>
+	body { margin: 0; background: #1b1b1b; color: #ddd; font: 15px/1.4 system-ui, sans-serif; }
+	header { display: flex; gap: 1.5em; align-items: baseline; padding: 0.8em 1.2em; background: #111; border-bottom: 1px solid #333; }
+	header b { color: #fff; letter-spacing: 0.1em; }
+	main { padding: 1em 1.2em; }
+	a { color: #9cf; text-decoration: none; }
+	a:hover { text-decoration: underline; }
+	h1 { font-size: 1.2em; font-weight: normal; margin: 0 0 1em; }
+	h2 { font-size: 1em; margin: 1.5em 0 0.5em; color: #fff; }
+	.folders { display: flex; flex-wrap: wrap; gap: 0.5em; margin-bottom: 1.5em; }
+	.folders a { padding: 0.4em 0.8em; background: #2a2a2a; border-radius: 4px; }
+	.grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(180px, 1fr)); gap: 0.8em; }
+	.small { grid-template-columns: repeat(auto-fill, minmax(110px, 1fr)); }
+	.tile { display: block; background: #222; border-radius: 4px; overflow: hidden; }
+	.tile img { display: block; width: 100%; aspect-ratio: 1; object-fit: contain; background: #111; }
+	.tile span { display: block; padding: 0.3em 0.5em; font-size: 0.8em; color: #aaa; overflow: hidden; text-overflow: ellipsis; white-space: nowrap; }
+	.invalid span { color: #e88; }
+	.entry { display: grid; grid-template-columns: 10em 1fr; gap: 1em; padding: 0.6em 0; border-top: 1px solid #2a2a2a; }
+	.photo { display: grid; grid-template-columns: minmax(0, 3fr) minmax(16em, 1fr); gap: 1.5em; }
+	.photo img { max-width: 100%; max-height: 80vh; background: #111; }
+	table { border-collapse: collapse; }
+	td { padding: 0.2em 1em 0.2em 0; vertical-align: top; }
+	td:first-child { color: #888; }
+	form input { background: #2a2a2a; color: #ddd; border: 1px solid #444; padding: 0.3em 0.5em; width: 24em; }
+	.problem { color: #e88; }
+	.muted { color: #888; }
+</style>
+</head>
+<body>
+<header>
+	<b>LOUPE</b>
+	<a href="/">Folders</a>
+	<a href="/timeline">Timeline</a>
+</header>
+<main>
+{{end}}
+
+{{define "bottom"}}
+</main>
+</body>
+</html>
+{{end}}
+
+{{define "tile"}}
+<a class="tile{{if not .Valid}} invalid{{end}}" href="/photo/{{.Path}}" title="{{.Filename}}">
+	<img src="{{.Thumbnail}}" alt="{{.Filename}}" loading="lazy">
+	<span>{{.Filename}}</span>
+</a>
+{{end}}
+
+{{define "browse"}}{{template "top" .}}
+<h1><a href="/">archive</a>{{range .Crumbs}} / <a href="{{.Link}}">{{.Name}}</a>{{end}}</h1>
+{{if .Folders}}<div class="folders">{{range .Folders}}<a href="{{.Link}}">{{.Name}}/</a>{{end}}</div>{{end}}
+{{if .Files}}<div class="grid">{{range .Files}}{{template "tile" .}}{{end}}</div>
+{{else if not .Folders}}<p class="muted">No image files here.</p>{{end}}
+{{template "bottom"}}{{end}}
+
+{{define "timeline"}}{{template "top" .}}
+<form action="/timeline">
+	<input name="q" value="{{.Query}}" placeholder="group=granite date=2023 version!=print">
+</form>
+{{if .Problem}}<p class="problem">{{.Problem}}</p>{{end}}
+<p class="muted">{{len .Entries}} photograph(s)</p>
+{{range .Entries}}
+<div class="entry">
+	<div>{{.Identifier}}</div>
+	<div class="grid small">{{range .Served}}{{template "tile" .}}{{end}}</div>
+</div>
+{{end}}
+{{template "bottom"}}{{end}}
+
+{{define "photo"}}{{template "top" .}}
+<h1><a href="{{.Folder}}">{{.Folder}}</a>{{.File.Filename}}</h1>
+<div class="photo">
+	<div><a href="{{.File.Image}}"><img src="{{.File.Image}}" alt="{{.File.Filename}}"></a></div>
+	<div>
+		{{with .File}}{{if .Valid}}
+		<table>
+			<tr><td>Identifier</td><td><a href="/timeline?q=date={{.Date}}+number={{.Number}}{{if has .Letter}}+letter={{.Letter}}{{end}}">{{.Identifier}}</a></td></tr>
+			<tr><td>Date</td><td>{{.Date}}</td></tr>
+			{{if has .Letter}}<tr><td>Roll</td><td>{{.Letter}}</td></tr>{{end}}
+			<tr><td>Number</td><td>{{.Number}}</td></tr>
+			{{if has .Class}}<tr><td>Class</td><td><a href="/timeline?q=class={{.Class}}">{{.Class}}</a></td></tr>{{end}}
+			<tr><td>Group</td><td><a href="/timeline?q=group={{.Group}}">{{.Group}}</a></td></tr>
+			<tr><td>Version</td><td>{{.Version}}</td></tr>
+			{{if has .Subversion}}<tr><td>Subversion</td><td>{{.Subversion}}</td></tr>{{end}}
+			<tr><td>Extension</td><td>{{.Extension}}</td></tr>
+		</table>
+		{{else}}
+		<p class="problem">Not validly named: {{.Problem}}</p>
+		{{end}}{{end}}
+		{{if .Related}}
+		<h2>Other files of this photograph</h2>
+		<div class="grid small">{{range .Related}}{{template "tile" .}}{{end}}</div>
+		{{end}}
+	</div>
+</div>
+{{template "bottom"}}{{end}}
+
+{{define "placeholder"}}<svg xmlns="http://www.w3.org/2000/svg" width="320" height="320" viewBox="0 0 320 320">
+<rect width="320" height="320" fill="#111"/>
+<text x="160" y="170" fill="#666" font-family="sans-serif" font-size="40" text-anchor="middle">{{.}}</text>
+</svg>{{end}}
+`))
@@ -0,0 +1,152 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	serve_test.go
+*/
+
+package main
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Records every file and folder under a directory along with its contents and modification time,
+// to tell whether anything changed
+func snapshot(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entry := info.ModTime().String()
+		if !d.IsDir() {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			entry += " " + string(data)
+		}
+		files[path] = entry
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// Serves an indexed archive that has changed since it was indexed, next to a file outside of it
+func testServer(t *testing.T) (root string, server *httptest.Server) {
+	t.Helper()
+	root = t.TempDir()
+	dir := filepath.Join(root, "archive")
+	writeTestFile(t, filepath.Join(dir, "granite", "masters", "20230101-001_granite_master.tif"))
+	err := index(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "chalk", "masters", "20230101-002_chalk_master.tif"))
+	writeTestFile(t, filepath.Join(root, "secret.tif"))
+
+	server = httptest.NewServer(newServer(dir).handler())
+	t.Cleanup(server.Close)
+	return root, server
+}
+
+func TestServeOnlyReads(t *testing.T) {
+	root, server := testServer(t)
+	before := snapshot(t, root)
+
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		for _, path := range []string{"/", "/api/photos", "/image/granite/masters/20230101-001_granite_master.tif"} {
+			request, err := http.NewRequest(method, server.URL+path, strings.NewReader("x"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+			if response.StatusCode != http.StatusMethodNotAllowed {
+				t.Errorf("%s %s got %d, expected %d", method, path, response.StatusCode, http.StatusMethodNotAllowed)
+			}
+		}
+	}
+
+	// Reading everything, including the file added since the archive was indexed
+	for _, path := range []string{
+		"/",
+		"/chalk/masters/",
+		"/timeline",
+		"/photo/chalk/masters/20230101-002_chalk_master.tif",
+		"/image/chalk/masters/20230101-002_chalk_master.tif",
+		"/thumbnail/chalk/masters/20230101-002_chalk_master.tif",
+		"/api/photos?q=group=chalk",
+		"/api/folders/granite/masters",
+		"/api/timeline",
+	} {
+		response, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Errorf("GET %s got %d", path, response.StatusCode)
+		}
+	}
+
+	after := snapshot(t, root)
+	for path, entry := range before {
+		if after[path] != entry {
+			t.Errorf("%s changed while serving", path)
+		}
+	}
+	for path := range after {
+		if _, ok := before[path]; !ok {
+			t.Errorf("%s was made while serving", path)
+		}
+	}
+}
+
+func TestServeStaysInArchive(t *testing.T) {
+	_, server := testServer(t)
+
+	for _, path := range []string{
+		"/image/../secret.tif",
+		"/image/../../secret.tif",
+		"/image/..%2fsecret.tif",
+		"/image/granite/..%2F..%2F..%2Fsecret.tif",
+		"/thumbnail/../secret.tif",
+		"/thumbnail/..%2f..%2fsecret.tif",
+		"/photo/../secret.tif",
+		"/api/photos/..%2fsecret.tif",
+		"/../secret.tif",
+	} {
+		response, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+
+		if response.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s got %d, expected %d", path, response.StatusCode, http.StatusNotFound)
+		}
+		if strings.Contains(string(body), "secret.tif") {
+			t.Errorf("GET %s gave away the file outside the archive", path)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	sidecar.go
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+/*
+	Sidecars are the files other programs keep next to a photograph, like edits from a raw
+	developer or a voice memo from the camera. They aren't photographs themselves, so they never
+	show up in getImageFiles, but they belong to one and have to follow it wherever it goes or
+	the edits are lost. A sidecar is tied to the photograph in the same folder that shares its
+	stem, either the whole filename (IMG_0001.CR2.xmp) or the name without the extension
+	(IMG_0001.xmp). When a raw and a JPEG share a stem, the sidecar goes with the raw.
+*/
+
+var sidecarExtensions = []string{".xmp", ".pp3", ".dop", ".on1", ".thm", ".wav"}
+
+// True if a file is a sidecar, judging by its extension
+func isSidecar(path string) bool {
+	return slices.Contains(sidecarExtensions, strings.ToLower(filepath.Ext(path)))
+}
+
+// Finds the sidecars of every image file in a directory, not looking in any subdirectories
+func getSidecars(dir string) (map[string][]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Join(errors.New("trouble while reading \""+dir+"\""), err)
+	}
+
+	var images, sidecars []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		if isImage(entry.Name()) {
+			images = append(images, entry.Name())
+		} else if isSidecar(entry.Name()) {
+			sidecars = append(sidecars, entry.Name())
+		}
+	}
+
+	found := make(map[string][]string)
+	for _, sidecar := range sidecars {
+		primary := sidecarPrimary(sidecar, images)
+		if primary != "" {
+			path := filepath.Join(dir, primary)
+			found[path] = append(found[path], filepath.Join(dir, sidecar))
+		}
+	}
+	return found, nil
+}
+
+// Picks which of the images in a folder a sidecar belongs to, or "" if none of them
+func sidecarPrimary(sidecar string, images []string) string {
+	rest := strings.TrimSuffix(sidecar, filepath.Ext(sidecar))
+
+	// The whole filename of the image, like IMG_0001.CR2.xmp
+	for _, image := range images {
+		if strings.EqualFold(image, rest) {
+			return image
+		}
+	}
+
+	// The stem of the image, like IMG_0001.xmp, preferring a raw over anything else
+	var matches []string
+	for _, image := range images {
+		if strings.EqualFold(strings.TrimSuffix(image, filepath.Ext(image)), rest) {
+			matches = append(matches, image)
+		}
+	}
+	slices.Sort(matches)
+	for _, image := range matches {
+		if slices.Contains(rawExtensions, strings.ToLower(filepath.Ext(image))) {
+			return image
+		}
+	}
+	if len(matches) > 0 {
+		return matches[0]
+	}
+
+	return ""
+}
+
+// Works out the new path of a sidecar when its photograph goes from one path to another, keeping
+// whatever the sidecar adds to the photograph's name or stem
+func sidecarPath(sidecar, from, to string) string {
+	name := filepath.Base(sidecar)
+	fromName, toName := filepath.Base(from), filepath.Base(to)
+
+	if len(name) > len(fromName) && strings.EqualFold(name[:len(fromName)], fromName) {
+		return filepath.Join(filepath.Dir(to), toName+name[len(fromName):])
+	}
+
+	fromStem := strings.TrimSuffix(fromName, filepath.Ext(fromName))
+	toStem := strings.TrimSuffix(toName, filepath.Ext(toName))
+	return filepath.Join(filepath.Dir(to), toStem+name[len(fromStem):])
+}
+
+// Adds an action for every sidecar of a file the plan moves, renames, copies or deletes, right
+// after the action for the file itself. A sidecar is only deleted if the plan made a copy of it
+// first or it is identical to one kept with the photograph's copy, so a sidecar with nothing to
+// restore it from is never lost. This has to happen before cleanEmptyDirs, so folders holding
+// nothing but sidecars aren't removed from under them
+func (p *Plan) followSidecars() error {
+	sidecars := make(map[string]map[string][]string)
+	sidecarsOf := func(path string) ([]string, error) {
+		dir := filepath.Dir(path)
+		if _, ok := sidecars[dir]; !ok {
+			found, err := getSidecars(dir)
+			if err != nil {
+				return nil, err
+			}
+			sidecars[dir] = found
+		}
+		return sidecars[dir][path], nil
+	}
+
+	actions := p.Actions
+	p.Actions = []Action{}
+	copies := make(map[string]string)
+	moved := make(map[string][]string) // Sidecars an earlier action took along to a path, like a temporary name
+	for _, a := range actions {
+		p.Actions = append(p.Actions, a)
+
+		switch a.Op {
+		case actionMove, actionRename, actionCopy:
+			found, ok := moved[a.From]
+			if !ok {
+				var err error
+				found, err = sidecarsOf(a.From)
+				if err != nil {
+					return err
+				}
+			}
+
+			for _, sidecar := range found {
+				newpath := sidecarPath(sidecar, a.From, a.To)
+				if p.taken(newpath) {
+					return errors.New("the sidecar \"" + sidecar + "\" can't follow its photograph, something is already at \"" + newpath + "\"")
+				}
+
+				if a.Op == actionCopy {
+					p.copy(sidecar, newpath)
+					copies[sidecar] = newpath
+				} else {
+					p.transfer(a.Op, sidecar, newpath)
+					moved[a.To] = append(moved[a.To], newpath)
+				}
+			}
+
+		case actionDelete:
+			found, err := sidecarsOf(a.Path)
+			if err != nil {
+				return err
+			}
+
+			// A sidecar that was copied somewhere can go. The sidecars of a duplicate that is
+			// deleted go to the copy that's kept instead, unless it already has a different
+			// sidecar by the same name, and then the duplicate is kept as well
+			var deletes, moves []Action
+			keep := false
+			for _, sidecar := range found {
+				if backup, ok := copies[sidecar]; ok {
+					deletes = append(deletes, Action{Path: sidecar, From: backup})
+					continue
+				}
+
+				newpath := sidecarPath(sidecar, a.Path, a.From)
+				if !p.taken(newpath) {
+					moves = append(moves, Action{From: sidecar, To: newpath})
+					continue
+				}
+
+				identical, err := p.identical(sidecar, newpath)
+				if err != nil {
+					return err
+				}
+				if !identical {
+					keep = true
+					break
+				}
+				deletes = append(deletes, Action{Path: sidecar, From: newpath})
+			}
+
+			if keep {
+				p.Actions = p.Actions[:len(p.Actions)-1]
+				delete(p.vacated, a.Path)
+				fmt.Println("Keeping", a.Path, "as well, its sidecars differ from the ones of", a.From)
+				continue
+			}
+			for _, d := range deletes {
+				p.delete(d.Path, d.From)
+			}
+			for _, m := range moves {
+				p.move(m.From, m.To)
+			}
+		}
+	}
+
+	return nil
+}
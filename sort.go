@@ -12,9 +12,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/karlramberg/loupe/photo"
 )
 
-func sort(dir string) error {
+func sort(dir string, removeDupes, dryRun bool, jsonPath string) error {
 	fmt.Println("Loupe", loupeVersion, "-", "Sort")
 
 	// Check that the -a flag was used
@@ -28,36 +31,33 @@ func sort(dir string) error {
 		return errors.New("directory \"" + dir + "\" not found")
 	}
 
-	// Get a list of image files in the directory and its subdirectories
-	files, err := getImageFiles(dir)
+	// Setup a scanner to standard input for the user to answer any questions
+	scanner := bufio.NewScanner(os.Stdin)
+
+	// Deal with a sort that was cut off before going any further
+	if !dryRun {
+		okay, err := recoverInterrupted(dir, scanner)
+		if err != nil || !okay {
+			return err
+		}
+	}
+
+	// Scan the directory and its subdirectories, sorting the files into valid and invalid slices
+	archive, err := scanArchive(dir)
 	if err != nil {
-		return errors.Join(errors.New("trouble getting image files from \""+dir+"\""), err)
+		return err
 	}
+	validPhotos, validFiles, invalidFiles := archive.photos, archive.files, archive.invalidFiles
+	total := len(validFiles) + len(invalidFiles)
 
 	// Check that the directory actually has image files to sort
-	if len(files) == 0 {
+	if total == 0 {
 		return errors.New("no image files found in \"" + dir + "\"")
 	}
 
-	// Sort the files into valid and invalid slices. Instantiate a Photograph struct for the valids
-	var validPhotos []Photograph
-	var validFiles []string
-	var invalidFiles []string
-	for _, file := range files {
-		var photograph Photograph
-		err := photograph.init(filepath.Base(file))
-		if err != nil {
-			fmt.Printf("Found invalid file: \"%s\", %s", file, err)
-			invalidFiles = append(invalidFiles, file)
-		} else {
-			validPhotos = append(validPhotos, photograph)
-			validFiles = append(validFiles, file)
-		}
-	}
-
 	// Ask for a confimation if the folder has less than 2/3rds validly named photos
-	if float64(len(validPhotos)) < (0.66 * float64(len(files))) {
-		scanner := bufio.NewScanner(os.Stdin)
+	// A dry run changes nothing, so there is nothing to confirm
+	if float64(len(validPhotos)) < (0.66*float64(total)) && !dryRun {
 		okay, err := promptConfimation(scanner,
 			"Less than 2/3rds of images in this directory are named correctly, do you wish to proceed?")
 		if err != nil {
@@ -70,95 +70,173 @@ func sort(dir string) error {
 		}
 	}
 
-	var duplicateCount int
-
-	// Move valids to their directories, creating them if they don't exist
-	for index, photo := range validPhotos {
-		// Check that the new directory exists, creating it if it doesn't
-		newdir := filepath.Join(dir, photo.directory())
-		_, err := os.Stat(newdir)
-		if os.IsNotExist(err) {
-			err := os.MkdirAll(newdir, 0755)
-			if err != nil {
-				return errors.Join(errors.New("trouble while creating directory \""+newdir+"\""), err)
-			}
-			fmt.Println("Created folder", newdir)
-		}
+	// Work out every move before touching anything
+	plan := newPlan("sort", dir)
+	plan.known = archive.known
+	conflicts, err := plan.sortFiles(dir, validPhotos, validFiles, invalidFiles, removeDupes)
+	if err != nil {
+		return err
+	}
 
-		// Move the file with the Rename function
-		oldpath := validFiles[index]
-		newpath := filepath.Join(newdir, photo.filename())
-		_, err = os.Stat(newpath)
-		if os.IsNotExist(err) && oldpath != newpath {
-			err = os.Rename(oldpath, newpath)
-			if err != nil {
-				return errors.Join(errors.New("trouble while moving \""+oldpath+"\""), err)
-			} else {
-				fmt.Println("Moved", filepath.Base(oldpath), "to", filepath.Dir(newpath))
-			}
-		} else if oldpath != newpath {
-			invalidFiles = append(invalidFiles, validFiles[index])
-			duplicateCount++
-			fmt.Println("Left", filepath.Base(oldpath), "alone, file already exists at the destination")
-		}
+	// Sidecars are moved along with their photographs
+	err = plan.followSidecars()
+	if err != nil {
+		return err
 	}
 
-	// Move invalids to the base folder
-	for _, oldpath := range invalidFiles {
-		newpath := filepath.Join(dir, filepath.Base(oldpath))
-		if oldpath != newpath {
-			err = os.Rename(oldpath, newpath)
-			if err != nil {
-				return errors.Join(errors.New("trouble while moving \""+oldpath+"\""), err)
-			} else {
-				fmt.Println("Moved invalid photo", filepath.Base(oldpath), "to", dir)
-			}
-		}
+	_, err = plan.cleanEmptyDirs(dir)
+	if err != nil {
+		return err
 	}
 
-	// Clean empty directories
-	_, err = cleanEmptyDirs(dir)
+	err = plan.run(dryRun, jsonPath)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println(len(validPhotos)-duplicateCount, "sorted photograph(s)")
-	fmt.Println(len(invalidFiles), "photograph(s) to be fixed")
+	// Identical duplicates that were deleted don't need fixing, the rest were moved to the base
+	// directory along with the invalid files, so point at where they are now
+	var leftOver []Conflict
+	for _, conflict := range conflicts {
+		if !plan.deletes(conflict.path) {
+			conflict.path = plan.destination(conflict.path)
+			leftOver = append(leftOver, conflict)
+		}
+	}
+	for i, file := range invalidFiles {
+		invalidFiles[i] = plan.destination(file)
+	}
+
+	fmt.Println(len(validPhotos)-len(conflicts), "sorted photograph(s)")
+	if removeDupes {
+		fmt.Println(len(conflicts)-len(leftOver), "identical duplicate(s) deleted")
+	}
+	fmt.Println(len(invalidFiles)+len(leftOver), "photograph(s) to be fixed")
+
+	// Point out exactly what is wrong with every file left in the base directory
+	if len(invalidFiles)+len(leftOver) > 0 {
+		fmt.Println()
+		fmt.Println("Fix list:")
+		fmt.Print(getFixList(invalidFiles))
+		fmt.Print(getConflictList(leftOver))
+	}
 
 	return nil
 }
 
-// Traverses a directory, recursively removing any empty subdirectories
-func cleanEmptyDirs(dir string) (bool, error) {
-	// Get a list of contents in the directory
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return false, errors.Join(errors.New("trouble while reading \""+dir+"\""), err)
+// A file that can't be sorted because another file already has its name at the destination
+type Conflict struct {
+	path        string // The file that couldn't be sorted
+	destination string // Where it would have gone
+	identical   bool   // True if the file already there has exactly the same contents
+}
+
+// Constructs a list of every conflict, saying whether it is safe to delete or needs a closer look
+func getConflictList(conflicts []Conflict) (list string) {
+	for _, conflict := range conflicts {
+		list += conflict.path + "\n"
+		if conflict.identical {
+			list += "    identical to " + conflict.destination + ", safe to delete with -remove-dupes\n"
+		} else {
+			list += "    a different file is already sorted at " + conflict.destination + ", check which one to keep\n"
+		}
 	}
+	return
+}
+
+// Constructs a list of every problem with each invalid file, pointing at where in the name it is
+func getFixList(files []string) (list string) {
+	for _, file := range files {
+		list += file + "\n"
+		name := filepath.Base(file)
+
+		_, err := photo.Parse(name)
+		var problems photo.ParseErrors
+		if !errors.As(err, &problems) {
+			continue
+		}
 
-	// Go through the entries, recursing on any other directory
-	removedEntries := 0
-	for _, entry := range entries {
-		if entry.IsDir() {
-			removed, err := cleanEmptyDirs(filepath.Join(dir, entry.Name()))
+		list += "    " + name + "\n"
+		for _, problem := range problems {
+			indent := "    " + strings.Repeat(" ", problem.Start)
+			list += indent + strings.Repeat("^", max(1, problem.End-problem.Start)) + " " + problem.Error() + "\n"
+			if problem.Fix != "" {
+				list += indent + "fix: " + problem.Fix + "\n"
+			}
+		}
+	}
+	return
+}
+
+// Plans moving valid photographs to their directories, creating them if they don't exist, and
+// moving invalid files to the base directory. The photos and files slices line up, so that
+// photos[i] is where files[i] should end up. This lets refactor hand over photographs that have
+// been renamed but not yet moved. Files whose destination is taken are compared to the file
+// there, and identical ones are deleted if removeDupes is set. Returns every file that couldn't
+// be sorted because of another.
+func (p *Plan) sortFiles(dir string, photos []photo.Photograph, files, invalidFiles []string, removeDupes bool) (conflicts []Conflict, err error) {
+	for index, photograph := range photos {
+		newdir := filepath.Join(dir, photograph.Directory())
+		oldpath := filepath.Clean(files[index])
+		newpath := filepath.Join(newdir, photograph.Filename())
+
+		if oldpath == newpath {
+			continue
+		}
+
+		if p.taken(newpath) {
+			identical, err := p.identical(oldpath, newpath)
 			if err != nil {
-				return false, err
+				return nil, err
 			}
-			if removed {
-				removedEntries++
+			conflicts = append(conflicts, Conflict{path: oldpath, destination: newpath, identical: identical})
+
+			switch {
+			case identical && removeDupes:
+				p.delete(oldpath, newpath)
+				fmt.Println("Deleting", filepath.Base(oldpath), "an identical file already exists at the destination")
+			case identical:
+				invalidFiles = append(invalidFiles, oldpath)
+				fmt.Println("Leaving", filepath.Base(oldpath), "alone, an identical file already exists at the destination")
+			default:
+				invalidFiles = append(invalidFiles, oldpath)
+				fmt.Println("Leaving", filepath.Base(oldpath), "alone, a different file already exists at the destination")
 			}
+			continue
 		}
+
+		p.mkdir(newdir)
+		p.move(oldpath, newpath)
 	}
 
-	// If the directory is originally empty or all entries have been deleted
-	if len(entries)-removedEntries <= 0 {
-		err := os.Remove(dir)
-		if err != nil {
-			return false, errors.Join(errors.New("trouble while deleting \""+dir+"\""), err)
+	// Move invalids to the base folder
+	for _, oldpath := range invalidFiles {
+		newpath := filepath.Join(dir, filepath.Base(oldpath))
+		if filepath.Clean(oldpath) != newpath && !p.taken(newpath) {
+			p.move(oldpath, newpath)
 		}
-		fmt.Println("Removed empty directory", dir)
-		return true, nil
 	}
 
-	return false, nil
+	return
+}
+
+// True if a file has the same contents as whatever will be at a path once the plan is done. The
+// path may not have been moved there yet, so the file the plan is moving there is read instead
+func (p *Plan) identical(path, other string) (bool, error) {
+	other = filepath.Clean(other)
+	for _, a := range p.Actions {
+		if (a.Op == actionMove || a.Op == actionRename || a.Op == actionCopy) && a.To == other {
+			other = a.From
+		}
+	}
+
+	sum, err := hashFile(path)
+	if err != nil {
+		return false, err
+	}
+	otherSum, err := hashFile(other)
+	if err != nil {
+		return false, err
+	}
+	return sum == otherSum, nil
 }
@@ -0,0 +1,16 @@
+//go:build darwin || freebsd || netbsd || openbsd
+
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	term_bsd.go
+*/
+
+package main
+
+import "syscall"
+
+const (
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
@@ -0,0 +1,14 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	term_linux.go
+*/
+
+package main
+
+import "syscall"
+
+const (
+	ioctlGetTermios = syscall.TCGETS
+	ioctlSetTermios = syscall.TCSETS
+)
@@ -0,0 +1,38 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	term_other.go
+*/
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// Anywhere else, the selector isn't available and every command asks for a selection expression
+
+type terminalState struct{}
+
+func makeRaw(fd uintptr) (*terminalState, error) {
+	return nil, errors.New("the selector isn't available on this system")
+}
+
+func setReadTimeout(fd uintptr, tenths uint8) error {
+	return errors.New("the selector isn't available on this system")
+}
+
+func restoreTerminal(fd uintptr, state *terminalState) error {
+	return nil
+}
+
+func terminalSize(fd uintptr) (width, height int, err error) {
+	return 0, 0, errors.New("the selector isn't available on this system")
+}
+
+func isTerminal(file *os.File) bool {
+	return false
+}
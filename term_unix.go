@@ -0,0 +1,91 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	term_unix.go
+*/
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+/*
+	NOTE: Loupe doesn't pull in a terminal library, so the few calls the selector needs are made
+	straight to the terminal driver. Everything but Linux names the calls the BSD way, which is
+	the only difference between the systems and lives in term_linux.go and term_bsd.go.
+*/
+
+// The terminal settings from before raw mode, to put back afterwards
+type terminalState struct {
+	termios syscall.Termios
+}
+
+// Switches the terminal to reading one key at a time without echoing it. Output is left alone, so
+// new lines still start at the left edge
+func makeRaw(fd uintptr) (*terminalState, error) {
+	var old syscall.Termios
+	if err := ioctl(fd, ioctlGetTermios, unsafe.Pointer(&old)); err != nil {
+		return nil, err
+	}
+
+	raw := old
+	raw.Iflag &^= syscall.ICRNL | syscall.IXON | syscall.BRKINT | syscall.ISTRIP | syscall.INPCK
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, ioctlSetTermios, unsafe.Pointer(&raw)); err != nil {
+		return nil, err
+	}
+	return &terminalState{termios: old}, nil
+}
+
+// Sets how long reading waits for a key in raw mode, in tenths of a second. With 0 it waits until
+// there is one, otherwise a read that times out returns nothing
+func setReadTimeout(fd uintptr, tenths uint8) error {
+	var raw syscall.Termios
+	if err := ioctl(fd, ioctlGetTermios, unsafe.Pointer(&raw)); err != nil {
+		return err
+	}
+
+	raw.Cc[syscall.VMIN], raw.Cc[syscall.VTIME] = 1, 0
+	if tenths > 0 {
+		raw.Cc[syscall.VMIN], raw.Cc[syscall.VTIME] = 0, tenths
+	}
+	return ioctl(fd, ioctlSetTermios, unsafe.Pointer(&raw))
+}
+
+// Puts the terminal back the way it was before makeRaw
+func restoreTerminal(fd uintptr, state *terminalState) error {
+	return ioctl(fd, ioctlSetTermios, unsafe.Pointer(&state.termios))
+}
+
+// Gets the width and height of the terminal in characters
+func terminalSize(fd uintptr) (width, height int, err error) {
+	var size struct {
+		rows, cols, x, y uint16
+	}
+	if err := ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&size)); err != nil {
+		return 0, 0, err
+	}
+	return int(size.cols), int(size.rows), nil
+}
+
+// True if the file is a terminal the selector can take over
+func isTerminal(file *os.File) bool {
+	var termios syscall.Termios
+	return ioctl(file.Fd(), ioctlGetTermios, unsafe.Pointer(&termios)) == nil
+}
+
+func ioctl(fd, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	if errno != 0 {
+		return errors.Join(errors.New("trouble talking to the terminal"), errno)
+	}
+	return nil
+}
@@ -0,0 +1,402 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	tui.go
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/karlramberg/loupe/photo"
+)
+
+/*
+	The selector is a full screen list of files to pick from with the keyboard, used instead of a
+	table and a selection expression whenever both ends of the command are a terminal. Setting
+	TERM=dumb, or running on a system without the terminal calls it needs, goes back to the
+	prompt. The keys are
+
+		up, down, k, j         move, page up and page down move a screen at a time
+		space                  select or unselect the file under the cursor
+		a                      select every file shown, or unselect them if they all are
+		/                      type a filter, enter or escape when done
+		enter                  done, with nothing selected every file shown is selected
+		q, escape, ctrl-c      cancel
+
+	A filter with an = in it is a query just like loupe find takes, like group=granite
+	date=2023, matched against the parsed name of each file. Anything else only has to be
+	somewhere in the filename. Files keep their selection while they are filtered out.
+*/
+
+// What the selector knows about each file
+type Selector struct {
+	files     []string
+	photos    []photo.Photograph
+	valid     []bool
+	preview   func(int) string // A line about the file under the cursor, like the name it would get
+	selected  []bool
+	shown     []int // The files that pass the filter
+	cursor    int   // Position in shown
+	top       int   // First position in shown on the screen
+	filter    string
+	filtering bool
+	status    string
+}
+
+// Asks for a selection of files, with the selector if it can be used and a selection expression
+// otherwise. Preview describes the file under the cursor in the selector, and can be nil
+func selectFiles(scanner *bufio.Scanner, files []string, preview func(int) string) ([]int, error) {
+	if canSelect() {
+		selections, err := runSelector(files, preview)
+		if err == nil {
+			return selections, nil
+		}
+		if err != errSelectorUnavailable {
+			return nil, err
+		}
+	}
+
+	fmt.Println(getFileTable(files))
+	return promptSelection(scanner, len(files))
+}
+
+var errSelectorUnavailable = errors.New("the selector isn't available")
+
+var errSelectionCancelled = errors.New("selection cancelled")
+
+// True if both ends of the command are a terminal that can do more than print lines
+func canSelect() bool {
+	term := os.Getenv("TERM")
+	return term != "" && term != "dumb" && isTerminal(os.Stdin) && isTerminal(os.Stdout)
+}
+
+// Runs the selector until the user is done or cancels
+func runSelector(files []string, preview func(int) string) ([]int, error) {
+	fd := os.Stdin.Fd()
+	state, err := makeRaw(fd)
+	if err != nil {
+		return nil, errSelectorUnavailable
+	}
+	defer restoreTerminal(fd, state)
+
+	s := newSelector(files, preview)
+
+	// Draw on the terminal's second screen so the command's output is still there afterwards
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	/*
+		NOTE: A read can hold several keys, like pasted text, or only part of one, like an arrow
+		key cut off over a slow connection. Whatever is read is kept until it makes whole keys.
+		The escape key on its own starts every escape sequence too, so when one is left waiting
+		the next read only waits a moment. Nothing coming means escape was pressed by itself.
+	*/
+	var pending []byte
+	waiting := false
+	input := make([]byte, 256)
+	for {
+		width, height, err := terminalSize(os.Stdout.Fd())
+		if err != nil || height < 5 {
+			width, height = 80, 24
+		}
+		fmt.Print(s.render(width, height))
+
+		if waiting != (len(pending) > 0) {
+			waiting = len(pending) > 0
+			timeout := uint8(0)
+			if waiting {
+				timeout = 1
+			}
+			if err := setReadTimeout(fd, timeout); err != nil {
+				return nil, err
+			}
+		}
+
+		n, err := os.Stdin.Read(input)
+		if waiting && n == 0 && (err == nil || err == io.EOF) {
+			// Timed out, so the first key left waiting was pressed on its own
+			done, err := s.press(string(pending[:1]), height)
+			pending = pending[1:]
+			if err != nil || done {
+				return s.finish(done, err)
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		pending = append(pending, input[:n]...)
+
+		for {
+			key, size := splitKey(pending)
+			if size == 0 {
+				break
+			}
+			pending = pending[size:]
+
+			done, err := s.press(key, height)
+			if err != nil || done {
+				return s.finish(done, err)
+			}
+		}
+	}
+}
+
+// The selections once the selector is done, or the error that stopped it
+func (s *Selector) finish(done bool, err error) ([]int, error) {
+	if err != nil {
+		return nil, err
+	}
+	return s.selections(), nil
+}
+
+// Splits the first whole key off of what was read, returning how many bytes it took. Returns 0
+// if the key isn't all there yet
+func splitKey(input []byte) (key string, size int) {
+	if len(input) == 0 {
+		return "", 0
+	}
+
+	if input[0] == '\x1b' {
+		if len(input) == 1 {
+			return "", 0
+		}
+
+		switch input[1] {
+		case '[':
+			// Parameters and intermediates come first, then one final byte ends the sequence
+			for i := 2; i < len(input); i++ {
+				switch {
+				case input[i] >= 0x40 && input[i] <= 0x7E:
+					return string(input[:i+1]), i + 1
+				case input[i] < 0x20 || input[i] > 0x3F:
+					// Not a sequence after all, so escape was pressed on its own
+					return "\x1b", 1
+				}
+			}
+			return "", 0
+		case 'O':
+			// Some terminals send arrow keys this way
+			if len(input) == 2 {
+				return "", 0
+			}
+			return "\x1b[" + string(input[2]), 3
+		}
+		return "\x1b", 1
+	}
+
+	if !utf8.FullRune(input) {
+		return "", 0
+	}
+	_, size = utf8.DecodeRune(input)
+	return string(input[:size]), size
+}
+
+func newSelector(files []string, preview func(int) string) *Selector {
+	s := &Selector{
+		files:    files,
+		photos:   make([]photo.Photograph, len(files)),
+		valid:    make([]bool, len(files)),
+		preview:  preview,
+		selected: make([]bool, len(files)),
+	}
+	for i, file := range files {
+		photograph, err := photo.Parse(filepath.Base(file))
+		s.photos[i], s.valid[i] = photograph, err == nil
+	}
+	s.applyFilter()
+	return s
+}
+
+// Handles one key press, returning true once the selection is done
+func (s *Selector) press(key string, height int) (bool, error) {
+	s.status = ""
+
+	if s.filtering {
+		switch key {
+		case "\r", "\n":
+			s.filtering = false
+		case "\x1b":
+			s.filtering = false
+			s.filter = ""
+		case "\x7f", "\b":
+			_, size := utf8.DecodeLastRuneInString(s.filter)
+			s.filter = s.filter[:len(s.filter)-size]
+		default:
+			if r, size := utf8.DecodeRuneInString(key); size == len(key) && r != utf8.RuneError && unicode.IsPrint(r) {
+				s.filter += key
+			}
+		}
+		s.applyFilter()
+		return false, nil
+	}
+
+	page := max(1, height-5)
+	switch key {
+	case "\x1b[A", "k":
+		s.move(-1)
+	case "\x1b[B", "j":
+		s.move(1)
+	case "\x1b[5~":
+		s.move(-page)
+	case "\x1b[6~":
+		s.move(page)
+	case " ":
+		if len(s.shown) > 0 {
+			i := s.shown[s.cursor]
+			s.selected[i] = !s.selected[i]
+			s.move(1)
+		}
+	case "a":
+		all := true
+		for _, i := range s.shown {
+			all = all && s.selected[i]
+		}
+		for _, i := range s.shown {
+			s.selected[i] = !all
+		}
+	case "/":
+		s.filtering = true
+	case "\r", "\n":
+		if !slices.Contains(s.selected, true) {
+			if len(s.shown) == 0 {
+				s.status = "Nothing to select"
+				return false, nil
+			}
+			for _, i := range s.shown {
+				s.selected[i] = true
+			}
+		}
+		return true, nil
+	case "q", "\x1b", "\x03":
+		return false, errSelectionCancelled
+	}
+	return false, nil
+}
+
+// Moves the cursor, keeping it on the list
+func (s *Selector) move(by int) {
+	s.cursor = max(0, min(len(s.shown)-1, s.cursor+by))
+}
+
+// Works out which files pass the filter. A query that isn't finished yet leaves the list as it was
+func (s *Selector) applyFilter() {
+	var query Query
+	if strings.Contains(s.filter, "=") {
+		var err error
+		query, err = parseQuery(strings.Fields(s.filter))
+		if err != nil {
+			s.status = strings.ReplaceAll(err.Error(), "\n", ", ")
+			return
+		}
+	}
+
+	s.shown = []int{}
+	for i, file := range s.files {
+		if query != nil {
+			if s.valid[i] && query.matches(s.photos[i]) {
+				s.shown = append(s.shown, i)
+			}
+		} else if strings.Contains(strings.ToLower(filepath.Base(file)), strings.ToLower(s.filter)) {
+			s.shown = append(s.shown, i)
+		}
+	}
+	s.cursor = max(0, min(len(s.shown)-1, s.cursor))
+}
+
+// The selected files in order
+func (s *Selector) selections() (selections []int) {
+	for i, selected := range s.selected {
+		if selected {
+			selections = append(selections, i)
+		}
+	}
+	return
+}
+
+// Draws the whole screen: a header, as much of the list as fits, the preview and a status line
+func (s *Selector) render(width, height int) string {
+	var screen strings.Builder
+	screen.WriteString("\x1b[H\x1b[2J")
+
+	count := 0
+	for _, selected := range s.selected {
+		if selected {
+			count++
+		}
+	}
+	line := fmt.Sprintf("Select files  %d of %d selected, %d shown", count, len(s.files), len(s.shown))
+	screen.WriteString(clip(line, width) + "\r\n")
+
+	// Scroll the list so the cursor is always on the screen
+	rows := height - 4
+	if s.cursor < s.top {
+		s.top = s.cursor
+	}
+	if s.cursor >= s.top+rows {
+		s.top = s.cursor - rows + 1
+	}
+	s.top = max(0, min(s.top, len(s.shown)-rows))
+
+	digits := len(strconv.Itoa(len(s.files)))
+	for row := 0; row < rows; row++ {
+		position := s.top + row
+		if position >= len(s.shown) {
+			screen.WriteString("\r\n")
+			continue
+		}
+
+		i := s.shown[position]
+		mark := " "
+		if s.selected[i] {
+			mark = "x"
+		}
+		line := fmt.Sprintf(" [%s] %*d. %s", mark, digits, i+1, s.files[i])
+		if position == s.cursor {
+			screen.WriteString("\x1b[7m" + clip(line, width) + "\x1b[0m\r\n")
+		} else {
+			screen.WriteString(clip(line, width) + "\r\n")
+		}
+	}
+
+	// Describe the file under the cursor
+	preview := ""
+	if s.preview != nil && len(s.shown) > 0 {
+		preview = s.preview(s.shown[s.cursor])
+	}
+	screen.WriteString(clip(preview, width) + "\r\n")
+
+	switch {
+	case s.filtering:
+		line = "Filter: " + s.filter + "_"
+		if s.status != "" {
+			line += "   " + s.status
+		}
+	case s.status != "":
+		line = s.status
+	case s.filter != "":
+		line = "Filter: " + s.filter + "   space select, a all, / filter, enter done, q cancel"
+	default:
+		line = "arrows move, space select, a all, / filter, enter done, q cancel"
+	}
+	screen.WriteString(clip(line, width))
+	return screen.String()
+}
+
+// Cuts a line down to the width of the terminal, counting characters and not bytes
+func clip(line string, width int) string {
+	if utf8.RuneCountInString(line) > width {
+		return string([]rune(line)[:max(0, width-1)]) + "~"
+	}
+	return line
+}
@@ -0,0 +1,108 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	tui_test.go
+*/
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// Splits everything read into keys, the way the selector does
+func splitKeys(input string) (keys []string, left string) {
+	pending := []byte(input)
+	for {
+		key, size := splitKey(pending)
+		if size == 0 {
+			return keys, string(pending)
+		}
+		keys = append(keys, key)
+		pending = pending[size:]
+	}
+}
+
+func TestSplitKey(t *testing.T) {
+	tests := []struct {
+		input string
+		keys  []string
+		left  string
+	}{
+		{"j", []string{"j"}, ""},
+		{"granite", []string{"g", "r", "a", "n", "i", "t", "e"}, ""},
+		{"\x1b[A\x1b[B", []string{"\x1b[A", "\x1b[B"}, ""},
+		{"\x1b[5~ ", []string{"\x1b[5~", " "}, ""},
+		{"\x1bOA", []string{"\x1b[A"}, ""},
+		{"\x1b", nil, "\x1b"},
+		{"\x1b[", nil, "\x1b["},
+		{"j\x1b[6", []string{"j"}, "\x1b[6"},
+		{"\x1bq", []string{"\x1b", "q"}, ""},
+		{"\x1b\x1b[A", []string{"\x1b", "\x1b[A"}, ""},
+		{"\x1b[\x7f", []string{"\x1b", "[", "\x7f"}, ""},
+		{"Ålesund", []string{"Å", "l", "e", "s", "u", "n", "d"}, ""},
+		{"dat\xc3", []string{"d", "a", "t"}, "\xc3"},
+		{"日本", []string{"日", "本"}, ""},
+	}
+
+	for _, test := range tests {
+		keys, left := splitKeys(test.input)
+		if !slices.Equal(keys, test.keys) || left != test.left {
+			t.Errorf("splitKey(%q) gave %q leaving %q, expected %q leaving %q", test.input, keys, left, test.keys, test.left)
+		}
+	}
+}
+
+// Typing into the filter takes pasted and multi-byte text, and backspace takes away whole characters
+func TestSelectorFilterInput(t *testing.T) {
+	s := newSelector([]string{"20230101-001_granite_master.tif", "Ålesund.tif", "scan.tif"}, nil)
+	press := func(input string) {
+		t.Helper()
+		keys, left := splitKeys(input)
+		if left != "" {
+			t.Fatalf("%q left %q", input, left)
+		}
+		for _, key := range keys {
+			if _, err := s.press(key, 24); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	press("/ålesund")
+	if s.filter != "ålesund" || !slices.Equal(s.shown, []int{1}) {
+		t.Errorf("filter %q shows %v, expected only Ålesund.tif", s.filter, s.shown)
+	}
+
+	press("\x7f\x7f\x7f\x7f\x7f\x7f")
+	if s.filter != "å" {
+		t.Errorf("backspace left %q, expected å", s.filter)
+	}
+
+	press("\x7fgranite\r")
+	if s.filtering || !slices.Equal(s.shown, []int{0}) {
+		t.Errorf("filter %q shows %v, expected only the granite file", s.filter, s.shown)
+	}
+}
+
+func TestClip(t *testing.T) {
+	tests := []struct {
+		line  string
+		width int
+		want  string
+	}{
+		{"granite", 10, "granite"},
+		{"granite", 7, "granite"},
+		{"granite", 5, "gran~"},
+		{"Ålesund", 7, "Ålesund"},
+		{"Ålesund fjord", 5, "Åles~"},
+		{"日本の写真", 3, "日本~"},
+	}
+
+	for _, test := range tests {
+		if got := clip(test.line, test.width); got != test.want {
+			t.Errorf("clip(%q, %d) = %q, expected %q", test.line, test.width, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	undo.go
+*/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func undo(dir string, dryRun bool, jsonPath string) error {
+	fmt.Println("Loupe", loupeVersion, "-", "Undo")
+
+	// Check that the -a flag was used
+	if dir == "" {
+		return errors.New("provide an archive directory using the -a flag")
+	}
+
+	// Check that the given directory exists
+	stats, err := os.Stat(dir)
+	if os.IsNotExist(err) || !stats.IsDir() {
+		return errors.New("directory \"" + dir + "\" not found")
+	}
+
+	// Setup a scanner to standard input for the user to answer any questions
+	scanner := bufio.NewScanner(os.Stdin)
+
+	// Finish or roll back an interrupted run first, an undo cut off partway through included, so
+	// the run it was undoing isn't skipped over as if it had been undone
+	if !dryRun {
+		okay, err := recoverInterrupted(dir, scanner)
+		if err != nil || !okay {
+			return err
+		}
+	}
+
+	records, err := readJournal(dir)
+	if err != nil {
+		return err
+	}
+
+	last := lastUndoable(journalRuns(records))
+	if last == nil {
+		return errors.New("nothing to undo in \"" + dir + "\"")
+	}
+
+	fmt.Println("Undoing", last.command, "from", last.id)
+	plan := undoPlan(dir, last)
+
+	// A dry run only shows the plan, there is nothing to confirm
+	if dryRun {
+		return plan.run(dryRun, jsonPath)
+	}
+
+	// Ask the user for a final confirmation of the changes
+	fmt.Print(plan.table())
+	okay, err := promptConfimation(scanner, "Undo these changes?")
+	if err != nil {
+		return err
+	}
+
+	if okay {
+		fmt.Println("Okay!")
+		return plan.run(dryRun, jsonPath)
+	}
+
+	fmt.Println("Aborting!")
+	return nil
+}
+
+// Finds the most recent run that hasn't already been undone, or nil if there isn't one. Undos are
+// runs of their own, so running undo twice steps further back instead of redoing the last change.
+// Only an undo that was committed counts, and one that was rolled back again doesn't
+func lastUndoable(runs []*JournalRun) *JournalRun {
+	// A run can only be reverted by a later one, so going from newest to oldest settles whether
+	// each undo still stands before the run it undoes is reached
+	reverted := make(map[string]bool)
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+		if run.undoes != "" && run.committed && !reverted[run.id] {
+			reverted[run.undoes] = true
+		}
+	}
+
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].undoes == "" && !reverted[runs[i].id] {
+			return runs[i]
+		}
+	}
+	return nil
+}
+
+// Plans reversing every action a journal run carried out, newest first
+func undoPlan(dir string, run *JournalRun) *Plan {
+	plan := newPlan("undo", dir)
+	plan.undoes = run.id
+
+	// An interrupted run may have moved or copied a file without getting the chance to mark it
+	// as done. Copies are only put in place once they are checked, so one that exists is finished
+	done := run.done
+	for step, a := range run.planned {
+		if run.doneSteps[step] {
+			continue
+		}
+		if (a.Op == actionMove || a.Op == actionRename) && !exists(a.From) && exists(a.To) {
+			done = append(done, a)
+		}
+		if a.Op == actionCopy && exists(a.To) {
+			done = append(done, a)
+		}
+	}
+
+	// Catch the manifest up with whatever the run did before it was cut off, before reversing it
+	plan.pending = done[run.manifested:]
+
+	for i := len(done) - 1; i >= 0; i-- {
+		a := done[i]
+		switch a.Op {
+		case actionMove:
+			plan.move(a.To, a.From)
+		case actionRename:
+			plan.rename(a.To, a.From)
+		case actionMkdir:
+			// Leave behind folders that have had other files put in them since
+			if plan.empty(a.Path) {
+				plan.rmdir(a.Path)
+			}
+		case actionRmdir:
+			plan.mkdir(a.Path)
+		case actionCopy:
+			// Only delete the copy if the original is still around, otherwise the copy is put back
+			if plan.taken(a.From) {
+				plan.delete(a.To, a.From)
+			} else {
+				plan.mkdir(filepath.Dir(a.From))
+				plan.move(a.To, a.From)
+			}
+		case actionDelete:
+			plan.mkdir(filepath.Dir(a.Path))
+			plan.copy(a.From, a.Path)
+		}
+	}
+
+	return plan
+}
+
+// Checks if the last run in a directory's journal was interrupted partway through, and asks
+// whether to resume it or roll it back. Returns false if the user would rather stop
+func recoverInterrupted(dir string, scanner *bufio.Scanner) (bool, error) {
+	records, err := readJournal(dir)
+	if err != nil {
+		return false, err
+	}
+
+	runs := journalRuns(records)
+	if len(runs) == 0 || !runs[len(runs)-1].incomplete() {
+		return true, nil
+	}
+
+	last := runs[len(runs)-1]
+	fmt.Printf("The %s from %s was interrupted after %d of %d changes\n",
+		last.command, last.id, len(last.doneSteps), len(last.planned))
+
+	choice, err := promptInput(scanner, "Enter resume, rollback or abort", "abort")
+	if err != nil {
+		return false, err
+	}
+
+	switch strings.ToLower(choice) {
+	case "resume":
+		plan := newPlan(last.command, dir)
+		plan.Actions = last.planned
+		plan.undoes = last.undoes
+		plan.resumes = last.id
+		plan.done = last.doneSteps
+		plan.pending = last.done[last.manifested:]
+		err = plan.execute()
+	case "rollback":
+		err = undoPlan(dir, last).execute()
+	default:
+		fmt.Println("Aborting!")
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	fmt.Println()
+	return true, nil
+}
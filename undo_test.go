@@ -0,0 +1,454 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	undo_test.go
+*/
+
+package main
+
+import (
+	"bufio"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Answers prompts with the given lines
+func testScanner(lines ...string) *bufio.Scanner {
+	return bufio.NewScanner(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+}
+
+// The paths in a directory's manifest, sorted
+func manifestPaths(t *testing.T, dir string) []string {
+	t.Helper()
+	manifest, found, err := readManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Fatal("no manifest in " + dir)
+	}
+
+	var paths []string
+	for path := range manifest {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// Builds a small hashed archive and a plan moving both of its files to a new group, which fails
+// halfway because something gets in the way of the second move
+func interruptedMove(t *testing.T) (dir string, blocker string) {
+	t.Helper()
+	dir = t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "granite", "masters", "20230101-001_granite_master.tif"))
+	writeTestFile(t, filepath.Join(dir, "granite", "masters", "20230101-002_granite_master.tif"))
+	err := hash(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newPlan("refactor", dir)
+	for _, name := range []string{"20230101-001_granite_master.tif", "20230101-002_granite_master.tif"} {
+		p.mkdir(filepath.Join(dir, "chalk", "masters"))
+		p.move(filepath.Join(dir, "granite", "masters", name), filepath.Join(dir, "chalk", "masters", name))
+	}
+
+	blocker = filepath.Join(dir, "chalk", "masters", "20230101-002_granite_master.tif")
+	writeTestFile(t, blocker)
+	err = p.execute()
+	if err == nil {
+		t.Fatal("expected the plan to fail on the file in the way")
+	}
+	return dir, blocker
+}
+
+func TestManifestFollowsFailedRun(t *testing.T) {
+	dir, blocker := interruptedMove(t)
+
+	// The first move happened, so the manifest has to know about it already
+	want := []string{"chalk/masters/20230101-001_granite_master.tif", "granite/masters/20230101-002_granite_master.tif"}
+	if got := manifestPaths(t, dir); !slices.Equal(got, want) {
+		t.Errorf("after failing the manifest has %v, expected %v", got, want)
+	}
+
+	// Resuming once the way is clear catches the manifest up with the rest
+	err := os.Remove(blocker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	okay, err := recoverInterrupted(dir, testScanner("resume"))
+	if err != nil || !okay {
+		t.Fatalf("resume returned %v, %v", okay, err)
+	}
+
+	want = []string{"chalk/masters/20230101-001_granite_master.tif", "chalk/masters/20230101-002_granite_master.tif"}
+	if got := manifestPaths(t, dir); !slices.Equal(got, want) {
+		t.Errorf("after resuming the manifest has %v, expected %v", got, want)
+	}
+}
+
+func TestManifestAfterKilledRun(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "granite", "masters", "20230101-001_granite_master.tif"))
+	err := hash(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestFile := filepath.Join(dir, loupeDir, manifestName)
+	before, err := os.ReadFile(manifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := filepath.Join(dir, "granite", "masters", "20230101-001_granite_master.tif")
+	to := filepath.Join(dir, "20230101-001_granite_master.tif")
+	p := newPlan("sort", dir)
+	p.move(from, to)
+	err = p.execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Make it look like the run died right after moving the file, before the manifest or the
+	// journal caught up
+	err = os.WriteFile(manifestFile, before, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journalFile := filepath.Join(dir, loupeDir, journalName)
+	data, err := os.ReadFile(journalFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if !strings.Contains(line, `"state":"`+stateManifest+`"`) && !strings.Contains(line, `"state":"`+stateCommitted+`"`) {
+			kept = append(kept, line)
+		}
+	}
+	err = os.WriteFile(journalFile, []byte(strings.Join(kept, "\n")+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	okay, err := recoverInterrupted(dir, testScanner("rollback"))
+	if err != nil || !okay {
+		t.Fatalf("rollback returned %v, %v", okay, err)
+	}
+
+	expectTestFile(t, from, "20230101-001_granite_master.tif")
+	want := []string{"granite/masters/20230101-001_granite_master.tif"}
+	if got := manifestPaths(t, dir); !slices.Equal(got, want) {
+		t.Errorf("after rolling back the manifest has %v, expected %v", got, want)
+	}
+	if problems, err := verify(dir); problems != 0 || err != nil {
+		t.Errorf("the manifest doesn't verify after rolling back: %d problem(s), %v", problems, err)
+	}
+}
+
+// Everything in a directory besides the _loupe folder, by path relative to it. Files map to what
+// they hold and folders to nothing
+func archiveContents(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	contents := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == loupeDir {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		contents[filepath.ToSlash(rel)] = ""
+		if !d.IsDir() {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			contents[filepath.ToSlash(rel)] = string(data)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+// Undoes the most recent run that hasn't been undone yet, the way loupe undo does once confirmed
+func undoLast(t *testing.T, dir string) {
+	t.Helper()
+	records, err := readJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := lastUndoable(journalRuns(records))
+	if last == nil {
+		t.Fatal("nothing to undo")
+	}
+	err = undoPlan(dir, last).execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Every kind of action is journaled, and undoing runs one at a time puts back each state before
+func TestExecuteUndoRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "20230101-001_granite_master.tif"))
+	writeTestFile(t, filepath.Join(dir, "20230101-002_granite_master.tif"))
+	writeTestFile(t, filepath.Join(dir, "20230101-003_granite_master.tif"))
+	writeTestFile(t, filepath.Join(dir, "copies", "20230101-003_granite_master.tif"))
+	err := os.MkdirAll(filepath.Join(dir, "leftover"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := archiveContents(t, dir)
+
+	first := newPlan("sort", dir)
+	first.mkdir(filepath.Join(dir, "granite", "masters"))
+	first.move(filepath.Join(dir, "20230101-001_granite_master.tif"), filepath.Join(dir, "granite", "masters", "20230101-001_granite_master.tif"))
+	first.copy(filepath.Join(dir, "20230101-002_granite_master.tif"), filepath.Join(dir, "granite", "masters", "20230101-002_granite_master.tif"))
+	first.delete(filepath.Join(dir, "20230101-003_granite_master.tif"), filepath.Join(dir, "copies", "20230101-003_granite_master.tif"))
+	first.rmdir(filepath.Join(dir, "leftover"))
+	err = first.execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sorted := archiveContents(t, dir)
+
+	second := newPlan("modify", dir)
+	second.rename(filepath.Join(dir, "granite", "masters", "20230101-001_granite_master.tif"), filepath.Join(dir, "granite", "masters", "20230101-004_granite_master.tif"))
+	err = second.execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every run was journaled from start to finish
+	records, err := readJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := journalRuns(records)
+	if len(runs) != 2 || runs[0].incomplete() || runs[1].incomplete() {
+		t.Fatalf("expected two finished runs in the journal, got %d", len(runs))
+	}
+	if len(runs[0].done) < len(first.Actions) {
+		t.Errorf("%d of %d actions of the first run were journaled as done", len(runs[0].done), len(first.Actions))
+	}
+
+	undoLast(t, dir)
+	if got := archiveContents(t, dir); !maps.Equal(got, sorted) {
+		t.Errorf("the first undo left %v, expected %v", got, sorted)
+	}
+
+	undoLast(t, dir)
+	if got := archiveContents(t, dir); !maps.Equal(got, original) {
+		t.Errorf("the second undo left %v, expected %v", got, original)
+	}
+
+	// Both undos are runs of their own, and nothing is left to undo
+	records, err = readJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs = journalRuns(records)
+	if len(runs) != 4 || runs[2].undoes != runs[1].id || runs[3].undoes != runs[0].id {
+		t.Errorf("expected the undos to be journaled against the runs they undid")
+	}
+	if last := lastUndoable(runs); last != nil {
+		t.Errorf("the %s from %s is still left to undo", last.command, last.id)
+	}
+}
+
+// The last run in a directory's journal
+func lastRun(t *testing.T, dir string) *JournalRun {
+	t.Helper()
+	records, err := readJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := journalRuns(records)
+	if len(runs) == 0 {
+		t.Fatal("nothing in the journal")
+	}
+	return runs[len(runs)-1]
+}
+
+func TestRecoverRoundTrip(t *testing.T) {
+	granite := func(dir, name string) string {
+		return filepath.Join(dir, "granite", "masters", name)
+	}
+	chalk := func(dir, name string) string {
+		return filepath.Join(dir, "chalk", "masters", name)
+	}
+
+	t.Run("abort", func(t *testing.T) {
+		dir, _ := interruptedMove(t)
+		okay, err := recoverInterrupted(dir, testScanner("abort"))
+		if err != nil || okay {
+			t.Fatalf("abort returned %v, %v", okay, err)
+		}
+		if !lastRun(t, dir).incomplete() {
+			t.Error("aborting marked the run as finished")
+		}
+		expectTestFile(t, chalk(dir, "20230101-001_granite_master.tif"), "20230101-001_granite_master.tif")
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		dir, blocker := interruptedMove(t)
+		okay, err := recoverInterrupted(dir, testScanner("rollback"))
+		if err != nil || !okay {
+			t.Fatalf("rollback returned %v, %v", okay, err)
+		}
+
+		expectTestFile(t, granite(dir, "20230101-001_granite_master.tif"), "20230101-001_granite_master.tif")
+		expectTestFile(t, granite(dir, "20230101-002_granite_master.tif"), "20230101-002_granite_master.tif")
+		expectTestFile(t, blocker, "20230101-002_granite_master.tif")
+		if exists(chalk(dir, "20230101-001_granite_master.tif")) {
+			t.Error("the moved file is still in its new folder")
+		}
+
+		// Rolling back finishes the journal, and leaves nothing to undo or recover
+		records, err := readJournal(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		runs := journalRuns(records)
+		if runs[len(runs)-1].incomplete() || lastUndoable(runs) != nil {
+			t.Error("the rolled back run is still open or can be undone")
+		}
+		okay, err = recoverInterrupted(dir, testScanner())
+		if err != nil || !okay {
+			t.Errorf("nothing should be left to recover, got %v, %v", okay, err)
+		}
+	})
+
+	t.Run("resume then undo", func(t *testing.T) {
+		dir, blocker := interruptedMove(t)
+		err := os.Remove(blocker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		okay, err := recoverInterrupted(dir, testScanner("resume"))
+		if err != nil || !okay {
+			t.Fatalf("resume returned %v, %v", okay, err)
+		}
+		expectTestFile(t, chalk(dir, "20230101-002_granite_master.tif"), "20230101-002_granite_master.tif")
+
+		// The resumed run carries on as the same run, so a single undo reverses all of it. The
+		// folder the file in the way was in was already there, so it stays
+		run := lastRun(t, dir)
+		if run.incomplete() || run.command != "refactor" || len(run.done) != 2 {
+			t.Fatalf("the resumed %s did %d action(s), expected both moves", run.command, len(run.done))
+		}
+		undoLast(t, dir)
+
+		want := map[string]string{
+			"granite":         "",
+			"granite/masters": "",
+			"granite/masters/20230101-001_granite_master.tif": "20230101-001_granite_master.tif",
+			"granite/masters/20230101-002_granite_master.tif": "20230101-002_granite_master.tif",
+			".":             "",
+			"chalk":         "",
+			"chalk/masters": "",
+		}
+		if got := archiveContents(t, dir); !maps.Equal(got, want) {
+			t.Errorf("undoing the resumed run left %v, expected %v", got, want)
+		}
+		manifest := []string{"granite/masters/20230101-001_granite_master.tif", "granite/masters/20230101-002_granite_master.tif"}
+		if got := manifestPaths(t, dir); !slices.Equal(got, manifest) {
+			t.Errorf("after undoing the manifest has %v, expected %v", got, manifest)
+		}
+	})
+}
+
+// An undo cut off partway through doesn't count as done, so the run it was undoing stays the one
+// left to undo until the undo is resumed, and again once the undo is rolled back
+func TestInterruptedUndo(t *testing.T) {
+	for _, choice := range []string{"resume", "rollback"} {
+		t.Run(choice, func(t *testing.T) {
+			dir := t.TempDir()
+			masters := filepath.Join(dir, "granite", "masters")
+			writeTestFile(t, filepath.Join(dir, "20230101-001_granite_master.tif"))
+			writeTestFile(t, filepath.Join(dir, "20230101-002_granite_master.tif"))
+
+			moved := newPlan("sort", dir)
+			moved.mkdir(masters)
+			moved.move(filepath.Join(dir, "20230101-001_granite_master.tif"), filepath.Join(masters, "20230101-001_granite_master.tif"))
+			moved.move(filepath.Join(dir, "20230101-002_granite_master.tif"), filepath.Join(masters, "20230101-002_granite_master.tif"))
+			err := moved.execute()
+			if err != nil {
+				t.Fatal(err)
+			}
+			first := lastRun(t, dir)
+			sorted := archiveContents(t, dir)
+
+			renamed := newPlan("modify", dir)
+			renamed.rename(filepath.Join(masters, "20230101-001_granite_master.tif"), filepath.Join(masters, "20230101-003_granite_master.tif"))
+			renamed.rename(filepath.Join(masters, "20230101-002_granite_master.tif"), filepath.Join(masters, "20230101-004_granite_master.tif"))
+			err = renamed.execute()
+			if err != nil {
+				t.Fatal(err)
+			}
+			second := lastRun(t, dir)
+
+			// Undoing the renames gets as far as the second file before a file is in the way
+			blocker := filepath.Join(masters, "20230101-001_granite_master.tif")
+			writeTestFile(t, blocker)
+			err = undoPlan(dir, second).execute()
+			if err == nil {
+				t.Fatal("expected the undo to fail on the file in the way")
+			}
+
+			records, err := readJournal(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if last := lastUndoable(journalRuns(records)); last == nil || last.id != second.id {
+				t.Fatal("the run the interrupted undo was undoing isn't the one left to undo")
+			}
+
+			if choice == "resume" {
+				err = os.Remove(blocker)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			okay, err := recoverInterrupted(dir, testScanner(choice))
+			if err != nil || !okay {
+				t.Fatalf("%s returned %v, %v", choice, okay, err)
+			}
+
+			records, err = readJournal(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			last := lastUndoable(journalRuns(records))
+			if choice == "resume" {
+				if got := archiveContents(t, dir); !maps.Equal(got, sorted) {
+					t.Errorf("resuming the undo left %v, expected %v", got, sorted)
+				}
+				if last == nil || last.id != first.id {
+					t.Error("the resumed undo didn't leave the first run to undo next")
+				}
+			} else {
+				expectTestFile(t, filepath.Join(masters, "20230101-003_granite_master.tif"), "20230101-001_granite_master.tif")
+				expectTestFile(t, filepath.Join(masters, "20230101-004_granite_master.tif"), "20230101-002_granite_master.tif")
+				if last == nil || last.id != second.id {
+					t.Error("rolling back the undo didn't leave the renames to undo again")
+				}
+			}
+		})
+	}
+}
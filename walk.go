@@ -0,0 +1,281 @@
+/*
+	Karl Ramberg
+	Loupe v0.1.0
+	walk.go
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karlramberg/loupe/photo"
+)
+
+/*
+	NOTE: Archives live on network drives as often as not, where reading a directory is slow but
+	reading many at once is nearly as fast as reading one. So instead of walking the tree one
+	directory at a time, scanWorkers goroutines take directories off a shared queue, add the
+	folders they find back onto it, and send out every image file as soon as it is found. The
+	number of goroutines stays the same however many directories the archive has. Files come out
+	in whatever order the directories finish, so anything that cares about order has to sort
+	them afterwards.
+*/
+
+// How many directories are read at once
+const scanWorkers = 16
+
+// Reads the entries of a directory for the scan. The benchmarks swap it for one that waits before
+// every read, the way a network drive does
+var readDir = os.ReadDir
+
+// An image file found while scanning, parsed if its name is valid
+type ScannedFile struct {
+	Path       string
+	Photograph photo.Photograph
+	Err        error // Why the name isn't valid, nil if it is
+}
+
+// Scans a directory and its subdirectories for image files, skipping any folder that starts with an
+// underscore. Files are sent over the first channel as they are found, and it is closed once the
+// scan is done. The first error stops the scan and is sent over the second channel, which is
+// closed after the first. Cancelling the context stops the scan too, with the context's error
+func scanImageFiles(ctx context.Context, dir string) (<-chan ScannedFile, <-chan error) {
+	files := make(chan ScannedFile, 256)
+	errs := make(chan error, 1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	var once sync.Once
+	fail := func(err error) {
+		once.Do(func() {
+			errs <- err
+			cancel()
+		})
+	}
+
+	// The directories waiting to be read, and how many are either waiting or being read. The
+	// scan is done once that reaches zero
+	var mu sync.Mutex
+	wake := sync.NewCond(&mu)
+	queue := []string{dir}
+	pending := 1
+
+	// Wake up every idle worker if the scan is cancelled, so they can stop
+	stopWaking := context.AfterFunc(ctx, func() {
+		mu.Lock()
+		wake.Broadcast()
+		mu.Unlock()
+	})
+
+	// Takes the next directory off the queue, waiting for one if the scan isn't done yet
+	next := func() (string, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		for len(queue) == 0 && pending > 0 && ctx.Err() == nil {
+			wake.Wait()
+		}
+		if len(queue) == 0 || ctx.Err() != nil {
+			return "", false
+		}
+		dir := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		return dir, true
+	}
+
+	// Puts the folders found in a directory on the queue and marks the directory as read
+	finish := func(subdirs []string) {
+		mu.Lock()
+		queue = append(queue, subdirs...)
+		pending += len(subdirs) - 1
+		wake.Broadcast()
+		mu.Unlock()
+	}
+
+	// Reads a directory, sending out its image files. Returns the folders in it to read next
+	read := func(dir string) (subdirs []string) {
+		entries, err := readDir(dir)
+		if err != nil {
+			fail(errors.Join(errors.New("there was trouble reading files from \""+dir+"\""), err))
+			return nil
+		}
+
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+
+			// Ignore any folder that starts with an underscore
+			if entry.IsDir() {
+				if entry.Name()[0] != '_' {
+					subdirs = append(subdirs, path)
+				}
+				continue
+			}
+
+			// Only send files that are an image
+			if !isImage(path) {
+				continue
+			}
+
+			photograph, err := photo.Parse(entry.Name())
+			select {
+			case files <- ScannedFile{Path: path, Photograph: photograph, Err: err}:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		return subdirs
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < scanWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dir, ok := next(); ok; dir, ok = next() {
+				finish(read(dir))
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		stopWaking()
+		if ctx.Err() != nil {
+			fail(ctx.Err())
+		}
+		cancel()
+		close(files)
+		close(errs)
+	}()
+
+	return files, errs
+}
+
+// True if a file is an image or a raw, judging by its extension
+func isImage(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return slices.Contains(imageExtensions, ext) || slices.Contains(rawExtensions, ext)
+}
+
+// Orders paths the way filepath.WalkDir visits them, folder by folder, so a scanned list of files
+// comes out in the same order every time
+func comparePaths(a, b string) int {
+	for {
+		// Compare the next name in each path, which runs up to the next separator
+		i, j := strings.IndexByte(a, filepath.Separator), strings.IndexByte(b, filepath.Separator)
+		nameA, nameB := a, b
+		if i >= 0 {
+			nameA = a[:i]
+		}
+		if j >= 0 {
+			nameB = b[:j]
+		}
+		if c := strings.Compare(nameA, nameB); c != 0 {
+			return c
+		}
+
+		// With every name so far the same, the shorter path comes first
+		switch {
+		case i < 0 && j < 0:
+			return 0
+		case i < 0:
+			return -1
+		case j < 0:
+			return 1
+		}
+		a, b = a[i+1:], b[j+1:]
+	}
+}
+
+// Every image file in an archive, split by whether it is validly named. The photos and files slices
+// line up like sortFiles expects
+type ScannedArchive struct {
+	photos       []photo.Photograph
+	files        []string
+	invalidFiles []string
+	known        map[string]bool // Every image file found, to look up instead of asking the disk
+}
+
+// Scans an archive for sort and refactor, printing how far along it is. Pressing Ctrl-C stops the
+// scan cleanly, which is always safe because nothing has been changed yet. Files are taken into
+// the archive as they arrive, but planning has to wait for the whole scan, since whether a
+// destination is free depends on every file in the archive
+func scanArchive(dir string) (ScannedArchive, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	archive := ScannedArchive{known: make(map[string]bool)}
+	count := newProgress("Scanning")
+	files, errs := scanImageFiles(ctx, dir)
+	for file := range files {
+		archive.known[file.Path] = true
+		if file.Err != nil {
+			archive.invalidFiles = append(archive.invalidFiles, file.Path)
+		} else {
+			archive.photos = append(archive.photos, file.Photograph)
+			archive.files = append(archive.files, file.Path)
+		}
+		count.add()
+	}
+	count.done()
+
+	if err := <-errs; err != nil {
+		return ScannedArchive{}, errors.Join(errors.New("trouble getting image files from \""+dir+"\""), err)
+	}
+
+	// Put the files in the same order every time, keeping each photograph with its file
+	order := make([]int, len(archive.files))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return comparePaths(archive.files[a], archive.files[b])
+	})
+	sortedPhotos := make([]photo.Photograph, len(order))
+	sortedFiles := make([]string, len(order))
+	for i, index := range order {
+		sortedPhotos[i], sortedFiles[i] = archive.photos[index], archive.files[index]
+	}
+	archive.photos, archive.files = sortedPhotos, sortedFiles
+	slices.SortFunc(archive.invalidFiles, comparePaths)
+
+	return archive, nil
+}
+
+// Keeps a running count on one line while a long scan is going. Nothing is printed unless
+// standard output is a terminal, so piped output stays clean
+type progress struct {
+	label    string
+	count    int
+	last     time.Time
+	terminal bool
+}
+
+func newProgress(label string) *progress {
+	stats, err := os.Stdout.Stat()
+	return &progress{label: label, terminal: err == nil && stats.Mode()&os.ModeCharDevice != 0}
+}
+
+// Counts one more file, redrawing the count at most ten times a second
+func (p *progress) add() {
+	p.count++
+	if p.terminal && time.Since(p.last) > 100*time.Millisecond {
+		fmt.Printf("\r%s... %d file(s)", p.label, p.count)
+		p.last = time.Now()
+	}
+}
+
+// Prints the final count and moves to a new line
+func (p *progress) done() {
+	if p.terminal {
+		fmt.Printf("\r%s... %d file(s)\n", p.label, p.count)
+	}
+}